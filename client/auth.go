@@ -0,0 +1,109 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Challenge is a parsed WWW-Authenticate header
+type Challenge struct {
+	Scheme string
+	Params map[string]string
+}
+
+// ParseChallenge parses a WWW-Authenticate header such as
+// Bearer realm="https://auth.example.com/token",service="registry",scope="repository:app:pull"
+func ParseChallenge(header string) (Challenge, bool) {
+	header = strings.TrimSpace(header)
+	scheme, rest, _ := strings.Cut(header, " ")
+	if scheme == "" {
+		return Challenge{}, false
+	}
+	c := Challenge{Scheme: strings.ToLower(scheme), Params: make(map[string]string)}
+
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimLeft(rest, ", ") {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimLeft(value, " ")
+		if strings.HasPrefix(value, `"`) {
+			// Quoted values may contain commas, and escaped quotes
+			var b strings.Builder
+			i := 1
+			for ; i < len(value) && value[i] != '"'; i++ {
+				if value[i] == '\\' && i+1 < len(value) {
+					i++
+				}
+				b.WriteByte(value[i])
+			}
+			c.Params[key] = b.String()
+			rest = value[min(i+1, len(value)):]
+		} else {
+			v, remaining, _ := strings.Cut(value, ",")
+			c.Params[key] = strings.TrimSpace(v)
+			rest = remaining
+		}
+	}
+	return c, true
+}
+
+// Reauthenticate answers the Bearer challenge of a 401 error by requesting a
+// token from its realm, using the basic credentials of auth when present,
+// and returns auth updated to send that token. The custom headers of auth are
+// only sent when the realm is on the registry host.
+func (c *Client) Reauthenticate(err error, auth AuthConfig) (AuthConfig, error) {
+	var regErr *RegistryError
+	if !errors.As(err, &regErr) || regErr.Challenge == "" {
+		return auth, fmt.Errorf("no authentication challenge to answer")
+	}
+	challenge, ok := ParseChallenge(regErr.Challenge)
+	if !ok || challenge.Scheme != "bearer" || challenge.Params["realm"] == "" {
+		return auth, fmt.Errorf("unsupported authentication challenge: %s", regErr.Challenge)
+	}
+
+	realm, parseErr := url.Parse(challenge.Params["realm"])
+	if parseErr != nil {
+		return auth, fmt.Errorf("invalid token realm %s: %v", challenge.Params["realm"], parseErr)
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if v := challenge.Params[key]; v != "" {
+			query.Set(key, v)
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	// The token endpoint only gets the basic credentials, never a stale token.
+	// The realm comes from the server and may be on any host, so custom
+	// -headers, often session cookies or API keys, only go to the registry host.
+	tokenAuth := AuthConfig{Username: auth.Username, Password: auth.Password}
+	if registryURL, err := url.Parse(regErr.URL); err == nil && strings.EqualFold(registryURL.Host, realm.Host) {
+		tokenAuth.Headers = auth.Headers
+	}
+	resp, doErr := c.Do("GET", realm.String(), tokenAuth, nil)
+	if doErr != nil {
+		return auth, fmt.Errorf("failed to fetch token from %s: %w", realm.Host, doErr)
+	}
+	defer resp.Body.Close()
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return auth, fmt.Errorf("failed to decode token from %s: %v", realm.Host, err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return auth, fmt.Errorf("token endpoint %s returned no token", realm.Host)
+	}
+
+	auth.Bearer = token.Token
+	return auth, nil
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		header string
+		want   Challenge
+		wantOK bool
+	}{
+		{
+			header: `Bearer realm="https://auth.example.com/token",service="registry",scope="repository:app:pull,push"`,
+			want: Challenge{Scheme: "bearer", Params: map[string]string{
+				"realm":   "https://auth.example.com/token",
+				"service": "registry",
+				"scope":   "repository:app:pull,push",
+			}},
+			wantOK: true,
+		},
+		{
+			header: `Basic realm="Registry \"prod\""`,
+			want:   Challenge{Scheme: "basic", Params: map[string]string{"realm": `Registry "prod"`}},
+			wantOK: true,
+		},
+		{
+			header: `Bearer realm=https://auth/token, service=registry`,
+			want:   Challenge{Scheme: "bearer", Params: map[string]string{"realm": "https://auth/token", "service": "registry"}},
+			wantOK: true,
+		},
+		{header: "", wantOK: false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseChallenge(tt.header)
+		if ok != tt.wantOK || (ok && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("ParseChallenge(%q) = %+v, %v, want %+v, %v", tt.header, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+// tokenServer issues a token and records the headers of the last request
+func tokenServer(t *testing.T) (*httptest.Server, *http.Header) {
+	t.Helper()
+	var last http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last = r.Header.Clone()
+		w.Write([]byte(`{"token":"t0k3n"}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &last
+}
+
+func TestReauthenticateHeaders(t *testing.T) {
+	realmSrv, last := tokenServer(t)
+	auth := AuthConfig{Username: "alice", Password: "secret", Headers: `{"Cookie":"session=abc"}`}
+
+	tests := []struct {
+		name        string
+		registryURL string
+		wantCookie  bool
+	}{
+		{name: "realm on the registry host", registryURL: realmSrv.URL + "/v2/app/tags/list", wantCookie: true},
+		{name: "realm on another host", registryURL: "https://registry.example.com/v2/app/tags/list"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := NewClientWithHTTPClient(100, false, &http.Client{}, "test")
+			challenge := &RegistryError{
+				URL:        tt.registryURL,
+				StatusCode: http.StatusUnauthorized,
+				Challenge:  `Bearer realm="` + realmSrv.URL + `/token",service="registry",scope="repository:app:pull"`,
+			}
+			got, err := cli.Reauthenticate(challenge, auth)
+			if err != nil {
+				t.Fatalf("Reauthenticate: %v", err)
+			}
+			if got.Bearer != "t0k3n" {
+				t.Errorf("Bearer = %q, want t0k3n", got.Bearer)
+			}
+			if user, pass, ok := (&http.Request{Header: *last}).BasicAuth(); !ok || user != "alice" || pass != "secret" {
+				t.Errorf("realm got basic credentials %q/%q, want alice/secret", user, pass)
+			}
+			if gotCookie := last.Get("Cookie") != ""; gotCookie != tt.wantCookie {
+				t.Errorf("realm got the -headers Cookie = %v, want %v", gotCookie, tt.wantCookie)
+			}
+		})
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -50,6 +49,12 @@ type Client struct {
 	HTTPClient *http.Client
 	Limiter    *rate.Limiter
 	UserAgent  string
+	Retry      RetryPolicy
+	RetryStats *RetryStats
+	// StorageClient fetches blobs redirected to external storage, so they can
+	// use a different proxy. HTTPClient is used when it is nil.
+	StorageClient *http.Client
+	Storage       *StorageStats
 }
 
 type AuthConfig struct {
@@ -59,122 +64,218 @@ type AuthConfig struct {
 	Headers  string
 }
 
-func NewClient(rateLimit int, insecure bool) *Client {
-	transport := &http.Transport{
-		MaxIdleConns:        1,                // Single connection to align with main.go
-		MaxIdleConnsPerHost: 1,                // Single connection per host
-		MaxConnsPerHost:     1,                // Limit to one connection to avoid concurrency
+// NewTransport returns the HTTP transport used for registry requests, with a
+// connection pool sized for poolSize parallel downloads
+func NewTransport(poolSize int) *http.Transport {
+	if poolSize < 1 {
+		poolSize = 1
+	}
+	return &http.Transport{
+		MaxIdleConns:        poolSize,
+		MaxIdleConnsPerHost: poolSize,
+		MaxConnsPerHost:     poolSize,
 		IdleConnTimeout:     90 * time.Second,
 		TLSHandshakeTimeout: 10 * time.Second,
-		ForceAttemptHTTP2:   false,            // Disable HTTP/2 to ensure keep-alive
+		ForceAttemptHTTP2:   false, // Disable HTTP/2 to ensure keep-alive
 	}
-	if insecure {
-		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+}
+
+func NewClient(rateLimit int, tlsOpts TLSOptions, poolSize int) (*Client, error) {
+	transport := NewTransport(poolSize)
+	if err := tlsOpts.Apply(transport); err != nil {
+		return nil, err
 	}
 
-	return &Client{
+	c := &Client{
 		HTTPClient: &http.Client{
 			Timeout:   600 * time.Second,
 			Transport: transport,
-			CheckRedirect: func(req *http.Request, via []*http.Request) error {
-				if len(via) >= 10 {
-					return fmt.Errorf("too many redirects")
-				}
-				return nil
-			},
 		},
-		Limiter: rate.NewLimiter(rate.Limit(rateLimit), 1),
+		Limiter:    rate.NewLimiter(rate.Limit(rateLimit), 1),
+		Retry:      DefaultRetryPolicy(),
+		RetryStats: NewRetryStats(),
+		Storage:    NewStorageStats(),
+	}
+	c.HTTPClient.CheckRedirect = c.checkRedirect
+
+	// Blob storage gets the trust settings but not the registry's client
+	// certificate, pins or server name
+	trust, err := tlsOpts.TrustConfig()
+	if err != nil {
+		return nil, err
 	}
+	storageTransport := NewTransport(poolSize)
+	storageTransport.TLSClientConfig = trust
+	c.StorageClient = &http.Client{Timeout: 600 * time.Second, Transport: storageTransport}
+	return c, nil
 }
 
 func NewClientWithHTTPClient(rateLimit int, insecure bool, httpClient *http.Client, userAgent string) *Client {
 	// Respect the provided httpClient's transport settings, only set TLSClientConfig if insecure is true and not already set
 	transport, ok := httpClient.Transport.(*http.Transport)
 	if !ok || transport == nil {
-		transport = &http.Transport{
-			MaxIdleConns:        1,
-			MaxIdleConnsPerHost: 1,
-			MaxConnsPerHost:     1,
-			IdleConnTimeout:     90 * time.Second,
-			TLSHandshakeTimeout: 10 * time.Second,
-			ForceAttemptHTTP2:   false,
-		}
+		transport = NewTransport(1)
 	}
 	if insecure && (transport.TLSClientConfig == nil || !transport.TLSClientConfig.InsecureSkipVerify) {
-		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		} else {
+			transport.TLSClientConfig = transport.TLSClientConfig.Clone()
+		}
+		transport.TLSClientConfig.InsecureSkipVerify = true
 	}
 	httpClient.Transport = transport
 	// Override timeout for downloads
 	httpClient.Timeout = 600 * time.Second
-	return &Client{
+	c := &Client{
 		HTTPClient: httpClient,
 		Limiter:    rate.NewLimiter(rate.Limit(rateLimit), 1),
 		UserAgent:  userAgent,
+		Retry:      DefaultRetryPolicy(),
+		RetryStats: NewRetryStats(),
+		Storage:    NewStorageStats(),
 	}
+	httpClient.CheckRedirect = c.checkRedirect
+	return c
 }
 
 func (c *Client) MakeRequest(url string, auth AuthConfig) (*http.Response, error) {
-	if err := c.Limiter.Wait(context.Background()); err != nil {
-		return nil, fmt.Errorf("rate limiter error: %v", err)
-	}
+	return c.Do("GET", url, auth, nil)
+}
 
-	const maxRetries = 3
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create request: %v", err)
+// Do sends a request with the given method and extra headers, applying the
+// rate limiter, authentication and the retry policy. Any non-2xx status is
+// returned as a *RegistryError.
+func (c *Client) Do(method, url string, auth AuthConfig, header http.Header) (*http.Response, error) {
+	maxAttempts := max(c.Retry.MaxAttempts, 1)
+	var waited time.Duration
+	for attempt := 1; ; attempt++ {
+		// Retries go through the limiter too, so backing off never bursts
+		if err := c.Limiter.Wait(context.Background()); err != nil {
+			return nil, fmt.Errorf("rate limiter error: %v", err)
 		}
 
-		req.Header.Set("User-Agent", c.UserAgent)
-		req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
-		req.Header.Set("Connection", "keep-alive") // Ensure keep-alive
-		if auth.Username != "" && auth.Password != "" {
-			req.SetBasicAuth(auth.Username, auth.Password)
-		}
-		if auth.Bearer != "" {
-			req.Header.Set("Authorization", "Bearer "+auth.Bearer)
-		}
-		if auth.Headers != "" {
-			var customHeaders map[string]string
-			if err := json.Unmarshal([]byte(auth.Headers), &customHeaders); err != nil {
-				return nil, fmt.Errorf("invalid headers JSON: %v", err)
-			}
-			for k, v := range customHeaders {
-				req.Header.Set(k, v)
-			}
+		req, err := c.newRequest(method, url, auth, header)
+		if err != nil {
+			return nil, err
 		}
 
 		resp, err := c.HTTPClient.Do(req)
+		if err == nil && isRedirect(resp) {
+			resp, err = c.followStorage(method, resp, header)
+		}
 		if err != nil {
-			// Only retry on timeout or specific SOCKS5 connection errors
-			if netErr, ok := err.(net.Error); ok && (netErr.Timeout() || isConnectionClosedError(err)) && attempt < maxRetries {
-				backoff := time.Duration(1<<uint(attempt-1)) * time.Second
-				fmt.Printf("[!] Request to %s failed: %v, retrying in %v (attempt %d/%d)\n", url, err, backoff, attempt, maxRetries)
-				time.Sleep(backoff)
-				continue
+			if !retryableError(err) {
+				return nil, fmt.Errorf("request failed: %v", err)
+			}
+			if !c.backoff(method, url, err.Error(), attempt, maxAttempts, &waited, nil) {
+				return nil, fmt.Errorf("request failed after %d attempts: %v", attempt, err)
 			}
-			return nil, fmt.Errorf("request failed: %v", err)
+			continue
 		}
 
-		if resp.StatusCode == http.StatusUnauthorized {
-			if authHeader := resp.Header.Get("Www-Authenticate"); authHeader != "" {
-				resp.Body.Close()
-				return nil, fmt.Errorf("unauthorized: %s", authHeader)
+		if retryableStatus(resp.StatusCode) {
+			regErr := newRegistryError(url, resp)
+			if !c.backoff(method, url, resp.Status, attempt, maxAttempts, &waited, resp) {
+				regErr.Attempts = attempt
+				return nil, regErr
 			}
+			continue
 		}
-		if resp.StatusCode != http.StatusOK {
-			resp.Body.Close()
-			return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return nil, newRegistryError(url, resp)
 		}
 
-		// Wrap response body with progress reader if Content-Length is available
-		if contentLength := resp.ContentLength; contentLength > 0 {
-			resp.Body = io.NopCloser(NewProgressReader(resp.Body, contentLength, url))
+		// Wrap response body with progress reader if Content-Length is available.
+		// Range requests are parts of a larger download and report progress there.
+		if contentLength := resp.ContentLength; contentLength > 0 && method == "GET" && req.Header.Get("Range") == "" {
+			resp.Body = progressBody{NewProgressReader(resp.Body, contentLength, url), resp.Body}
 		}
 
 		return resp, nil
 	}
-	return nil, fmt.Errorf("request failed after %d retries", maxRetries)
+}
+
+// Probe sends a single request through the rate limiter and returns the
+// response whatever its status, with up to maxProbeBody bytes of the body
+// already read. It does not retry or follow redirects to other hosts, so
+// fingerprinting sees exactly what the registry answered.
+func (c *Client) Probe(method, url string, auth AuthConfig, header http.Header) (*http.Response, []byte, error) {
+	if err := c.Limiter.Wait(context.Background()); err != nil {
+		return nil, nil, fmt.Errorf("rate limiter error: %v", err)
+	}
+	req, err := c.newRequest(method, url, auth, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeBody))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response from %s: %v", url, err)
+	}
+	return resp, body, nil
+}
+
+// maxProbeBody bounds how much of a probed response is kept
+const maxProbeBody = 1 << 20
+
+// newRequest builds a registry request with the User-Agent, authentication
+// and custom headers applied
+func (c *Client) newRequest(method, url string, auth AuthConfig, header http.Header) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	req.Header.Set("User-Agent", c.UserAgent)
+	req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
+	req.Header.Set("Connection", "keep-alive") // Ensure keep-alive
+	if auth.Username != "" && auth.Password != "" {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	}
+	if auth.Bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+auth.Bearer)
+	}
+	if auth.Headers != "" {
+		var customHeaders map[string]string
+		if err := json.Unmarshal([]byte(auth.Headers), &customHeaders); err != nil {
+			return nil, fmt.Errorf("invalid headers JSON: %v", err)
+		}
+		for k, v := range customHeaders {
+			req.Header.Set(k, v)
+		}
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return req, nil
+}
+
+// backoff waits before the next attempt of a failed request and records the
+// retry. It returns false, without waiting, when the attempts or the total
+// retry time of the policy are exhausted.
+func (c *Client) backoff(method, url, reason string, attempt, maxAttempts int, waited *time.Duration, resp *http.Response) bool {
+	delay := c.Retry.delay(attempt, resp)
+	if attempt >= maxAttempts || *waited+delay > c.Retry.MaxElapsed {
+		c.RetryStats.record(method, url, reason, true)
+		return false
+	}
+	c.RetryStats.record(method, url, reason, false)
+	fmt.Printf("[!] Request to %s failed: %s, retrying in %v (attempt %d/%d)\n", url, reason, delay.Round(time.Millisecond), attempt, maxAttempts)
+	time.Sleep(delay)
+	*waited += delay
+	return true
+}
+
+// progressBody reports progress while still closing the underlying response body
+type progressBody struct {
+	io.Reader
+	io.Closer
 }
 
 // isConnectionClosedError checks if the error is related to a closed connection
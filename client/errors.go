@@ -0,0 +1,150 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrorCode is an error code defined by the distribution specification
+type ErrorCode string
+
+const (
+	CodeUnauthorized      ErrorCode = "UNAUTHORIZED"
+	CodeDenied            ErrorCode = "DENIED"
+	CodeNameUnknown       ErrorCode = "NAME_UNKNOWN"
+	CodeManifestUnknown   ErrorCode = "MANIFEST_UNKNOWN"
+	CodeBlobUnknown       ErrorCode = "BLOB_UNKNOWN"
+	CodeTooManyRequests   ErrorCode = "TOOMANYREQUESTS"
+	CodeUnsupported       ErrorCode = "UNSUPPORTED"
+	CodeNameInvalid       ErrorCode = "NAME_INVALID"
+	CodeManifestInvalid   ErrorCode = "MANIFEST_INVALID"
+	CodeDigestInvalid     ErrorCode = "DIGEST_INVALID"
+	CodeSizeInvalid       ErrorCode = "SIZE_INVALID"
+	CodeUnknown           ErrorCode = "UNKNOWN"
+	CodeTagInvalid        ErrorCode = "TAG_INVALID"
+	CodeBlobUploadUnknown ErrorCode = "BLOB_UPLOAD_UNKNOWN"
+)
+
+// Sentinel errors matched by errors.Is against a *RegistryError
+var (
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrDenied          = errors.New("access denied")
+	ErrNameUnknown     = errors.New("repository name not known to registry")
+	ErrManifestUnknown = errors.New("manifest unknown")
+	ErrBlobUnknown     = errors.New("blob unknown")
+	ErrTooManyRequests = errors.New("too many requests")
+	ErrUnsupported     = errors.New("operation not supported")
+)
+
+// codeErrors maps error codes to their sentinel errors
+var codeErrors = map[ErrorCode]error{
+	CodeUnauthorized:    ErrUnauthorized,
+	CodeDenied:          ErrDenied,
+	CodeNameUnknown:     ErrNameUnknown,
+	CodeManifestUnknown: ErrManifestUnknown,
+	CodeBlobUnknown:     ErrBlobUnknown,
+	CodeTooManyRequests: ErrTooManyRequests,
+	CodeUnsupported:     ErrUnsupported,
+}
+
+// statusErrors maps HTTP statuses to sentinel errors for registries that
+// reply without an error body
+var statusErrors = map[int]error{
+	http.StatusUnauthorized:     ErrUnauthorized,
+	http.StatusForbidden:        ErrDenied,
+	http.StatusTooManyRequests:  ErrTooManyRequests,
+	http.StatusMethodNotAllowed: ErrUnsupported,
+}
+
+// ErrorDetail is one entry of a registry error body
+type ErrorDetail struct {
+	Code    ErrorCode       `json:"code"`
+	Message string          `json:"message"`
+	Detail  json.RawMessage `json:"detail,omitempty"`
+}
+
+// RegistryError is returned for any non-2xx registry response
+type RegistryError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	Errors     []ErrorDetail
+	// Challenge is the WWW-Authenticate header of a 401 response
+	Challenge string
+	// Attempts is set when the request was abandoned after retries
+	Attempts int
+}
+
+func (e *RegistryError) Error() string {
+	msg := "unexpected status: " + e.Status
+	if e.StatusCode == http.StatusUnauthorized {
+		msg = "unauthorized: " + e.Status
+	}
+	var details []string
+	for _, d := range e.Errors {
+		detail := string(d.Code)
+		if d.Message != "" {
+			detail += ": " + d.Message
+		}
+		if len(d.Detail) > 0 && string(d.Detail) != "null" {
+			detail += " (" + string(d.Detail) + ")"
+		}
+		details = append(details, detail)
+	}
+	if len(details) > 0 {
+		msg += ": " + strings.Join(details, "; ")
+	}
+	if e.Challenge != "" {
+		msg += " [" + e.Challenge + "]"
+	}
+	if e.Attempts > 0 {
+		msg += fmt.Sprintf(" (gave up after %d attempts)", e.Attempts)
+	}
+	return msg
+}
+
+// Code returns the first error code of the body, or an empty code if the
+// registry sent none
+func (e *RegistryError) Code() ErrorCode {
+	if len(e.Errors) == 0 {
+		return ""
+	}
+	return e.Errors[0].Code
+}
+
+// Is lets errors.Is match the sentinel errors for the codes in the body,
+// falling back to the HTTP status when the body carries no known code
+func (e *RegistryError) Is(target error) bool {
+	for _, d := range e.Errors {
+		if codeErrors[d.Code] == target {
+			return true
+		}
+	}
+	return statusErrors[e.StatusCode] == target
+}
+
+// newRegistryError reads and closes the body of a failed response
+func newRegistryError(url string, resp *http.Response) *RegistryError {
+	defer resp.Body.Close()
+	e := &RegistryError{
+		URL:        url,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Challenge:  resp.Header.Get("Www-Authenticate"),
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil || len(body) == 0 {
+		return e
+	}
+	var parsed struct {
+		Errors []ErrorDetail `json:"errors"`
+	}
+	if json.Unmarshal(body, &parsed) == nil {
+		e.Errors = parsed.Errors
+	}
+	return e
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TokenAccess is an entry of the access claim of a registry token, e.g.
+// {"type": "repository", "name": "team/app", "actions": ["pull", "push"]}
+type TokenAccess struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Actions []string `json:"actions"`
+}
+
+// String formats the entry like a token scope, type:name:actions
+func (a TokenAccess) String() string {
+	return a.Type + ":" + a.Name + ":" + strings.Join(a.Actions, ",")
+}
+
+// TokenClaims are the claims of a registry JWT that dockdiver reports
+type TokenClaims struct {
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	Audience  json.RawMessage `json:"aud"` // A string or a list of strings
+	ExpiresAt int64           `json:"exp"`
+	NotBefore int64           `json:"nbf"`
+	IssuedAt  int64           `json:"iat"`
+	Access    []TokenAccess   `json:"access"`
+}
+
+// ErrNotJWT is returned by DecodeToken for opaque tokens
+var ErrNotJWT = errors.New("token is not a JWT")
+
+// DecodeToken decodes the claims of a JWT without verifying its signature;
+// they only tell what the token service meant to grant
+func DecodeToken(token string) (*TokenClaims, error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil, ErrNotJWT
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid payload encoding: %v", ErrNotJWT, err)
+	}
+	var claims TokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: invalid payload: %v", ErrNotJWT, err)
+	}
+	return &claims, nil
+}
+
+// Audiences returns the aud claim as a list
+func (c *TokenClaims) Audiences() []string {
+	var one string
+	if json.Unmarshal(c.Audience, &one) == nil {
+		if one == "" {
+			return nil
+		}
+		return []string{one}
+	}
+	var many []string
+	json.Unmarshal(c.Audience, &many)
+	return many
+}
+
+// Expiry returns when the token expires, or the zero time if it does not say
+func (c *TokenClaims) Expiry() time.Time {
+	if c.ExpiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// Expired reports whether the token has expired at now
+func (c *TokenClaims) Expired(now time.Time) bool {
+	return c.ExpiresAt != 0 && !now.Before(c.Expiry())
+}
+
+// Allows reports whether the access claim grants action on the resource of
+// the given type and name, honouring "*" for names and actions
+func (c *TokenClaims) Allows(resourceType, name, action string) bool {
+	for _, a := range c.Access {
+		if a.Type != resourceType || (a.Name != name && a.Name != "*") {
+			continue
+		}
+		for _, granted := range a.Actions {
+			if granted == action || granted == "*" {
+				return true
+			}
+		}
+	}
+	return false
+}
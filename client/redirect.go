@@ -0,0 +1,162 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const maxRedirects = 10
+
+// checkRedirect follows redirects within the registry host only. Redirects
+// to another host, typically a pre-signed URL on blob storage, are handed
+// back to Do so they can be fetched without registry credentials.
+func (c *Client) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("too many redirects")
+	}
+	if req.URL.Host != via[0].URL.Host {
+		return http.ErrUseLastResponse
+	}
+	req.Header.Set("User-Agent", c.UserAgent)
+	req.Header.Set("Connection", "keep-alive")
+	return nil
+}
+
+// isRedirect reports whether resp sends the client to another URL
+func isRedirect(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return resp.Header.Get("Location") != ""
+	}
+	return false
+}
+
+// followStorage fetches the target of a cross-host redirect with a clean
+// request: no Authorization, no custom -headers and no registry Accept
+// header, which would leak credentials or break a pre-signed signature.
+// Only the caller's extra headers, such as Range, are kept.
+func (c *Client) followStorage(method string, resp *http.Response, header http.Header) (*http.Response, error) {
+	storageClient := c.StorageClient
+	if storageClient == nil {
+		storageClient = c.HTTPClient
+	}
+	for i := 0; isRedirect(resp); i++ {
+		resp.Body.Close()
+		if i >= maxRedirects {
+			return nil, fmt.Errorf("too many redirects")
+		}
+		location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid redirect location: %v", err)
+		}
+		c.Storage.record(location)
+
+		req, err := http.NewRequest(method, location.String(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %v", err)
+		}
+		req.Header.Set("User-Agent", c.UserAgent)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err = storageClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("request to storage %s failed: %w", location.Host, err)
+		}
+	}
+	return resp, nil
+}
+
+// StorageBackend is an external blob store the registry redirected to
+type StorageBackend struct {
+	Provider  string `json:"provider"`
+	Host      string `json:"host"`
+	Bucket    string `json:"bucket,omitempty"`
+	Redirects int    `json:"redirects"`
+}
+
+// StorageStats collects the storage backends seen during a run
+type StorageStats struct {
+	mu       sync.Mutex
+	backends map[string]*StorageBackend
+}
+
+// NewStorageStats returns an empty collector
+func NewStorageStats() *StorageStats {
+	return &StorageStats{backends: make(map[string]*StorageBackend)}
+}
+
+// record notes a redirect to u
+func (s *StorageStats) record(u *url.URL) {
+	if s == nil {
+		return
+	}
+	provider, bucket := identifyStorage(u.Hostname(), u.EscapedPath())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := u.Hostname() + "/" + bucket
+	b, ok := s.backends[key]
+	if !ok {
+		b = &StorageBackend{Provider: provider, Host: u.Hostname(), Bucket: bucket}
+		s.backends[key] = b
+	}
+	b.Redirects++
+}
+
+// Summary returns the storage backends sorted by host and bucket
+func (s *StorageStats) Summary() []StorageBackend {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]StorageBackend, 0, len(s.backends))
+	for _, b := range s.backends {
+		result = append(result, *b)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Host != result[j].Host {
+			return result[i].Host < result[j].Host
+		}
+		return result[i].Bucket < result[j].Bucket
+	})
+	return result
+}
+
+// identifyStorage names the cloud provider behind a host and extracts the
+// bucket (or Azure account/container) from the host or the first path segment
+func identifyStorage(host, path string) (provider, bucket string) {
+	host = strings.ToLower(host)
+	firstSegment := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+
+	switch {
+	case strings.HasSuffix(host, ".amazonaws.com") && strings.Contains(host, "s3"):
+		// Virtual-hosted style puts the bucket before the s3 label, path style after it
+		labels := strings.Split(host, ".")
+		for i, label := range labels {
+			if label == "s3" || strings.HasPrefix(label, "s3-") {
+				if i > 0 {
+					return "AWS S3", strings.Join(labels[:i], ".")
+				}
+				return "AWS S3", firstSegment
+			}
+		}
+		return "AWS S3", ""
+	case host == "storage.googleapis.com":
+		return "Google Cloud Storage", firstSegment
+	case strings.HasSuffix(host, ".storage.googleapis.com"):
+		return "Google Cloud Storage", strings.TrimSuffix(host, ".storage.googleapis.com")
+	case strings.HasSuffix(host, ".blob.core.windows.net"):
+		return "Azure Blob Storage", strings.TrimSuffix(host, ".blob.core.windows.net") + "/" + firstSegment
+	case strings.HasSuffix(host, ".r2.cloudflarestorage.com"):
+		return "Cloudflare R2", firstSegment
+	case strings.HasSuffix(host, ".cloudfront.net"):
+		return "AWS CloudFront", ""
+	}
+	return "unknown", ""
+}
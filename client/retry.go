@@ -0,0 +1,152 @@
+package client
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RetryPolicy decides which failed requests are retried and how long to wait
+// between attempts
+type RetryPolicy struct {
+	MaxAttempts int           // Total attempts per request, including the first
+	BaseDelay   time.Duration // Backoff before the first retry, doubled after each attempt
+	MaxDelay    time.Duration // Upper bound of a single backoff
+	MaxElapsed  time.Duration // Total time a request may spend waiting between retries
+}
+
+// DefaultRetryPolicy returns the policy used by new clients
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   time.Second,
+		MaxDelay:    30 * time.Second,
+		MaxElapsed:  2 * time.Minute,
+	}
+}
+
+// retryableStatus reports whether a response status is worth retrying:
+// rate limiting and transient gateway or availability errors
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryableError reports whether a transport error is worth retrying
+func retryableError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return isConnectionClosedError(err)
+}
+
+// backoff returns the wait before retry number attempt (1-based), using
+// exponential backoff with full jitter
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay << uint(attempt-1)
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// delay returns how long to wait before retrying a response, honouring a
+// Retry-After header when the server sent one
+func (p RetryPolicy) delay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			return d
+		}
+	}
+	return p.backoff(attempt)
+}
+
+// parseRetryAfter parses a Retry-After value given either as seconds or as
+// an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// RetryRecord summarises the retries of a single request
+type RetryRecord struct {
+	Method    string
+	URL       string
+	Retries   int
+	LastError string
+	GaveUp    bool
+}
+
+// RetryStats collects retries across all requests of a run
+type RetryStats struct {
+	mu      sync.Mutex
+	records map[string]*RetryRecord
+}
+
+// NewRetryStats returns an empty collector
+func NewRetryStats() *RetryStats {
+	return &RetryStats{records: make(map[string]*RetryRecord)}
+}
+
+// record notes one retry of a request, or that it was abandoned
+func (s *RetryStats) record(method, url, reason string, gaveUp bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := method + " " + url
+	r, ok := s.records[key]
+	if !ok {
+		r = &RetryRecord{Method: method, URL: url}
+		s.records[key] = r
+	}
+	if !gaveUp {
+		r.Retries++
+	}
+	r.LastError = reason
+	r.GaveUp = r.GaveUp || gaveUp
+}
+
+// Summary returns the requests that needed retries, sorted by URL
+func (s *RetryStats) Summary() []RetryRecord {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]RetryRecord, 0, len(s.records))
+	for _, r := range s.records {
+		result = append(result, *r)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].URL != result[j].URL {
+			return result[i].URL < result[j].URL
+		}
+		return result[i].Method < result[j].Method
+	})
+	return result
+}
@@ -0,0 +1,31 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{value: "", wantOK: false},
+		{value: "0", want: 0, wantOK: true},
+		{value: "120", want: 2 * time.Minute, wantOK: true},
+		{value: "-5", wantOK: false},
+		{value: "Fri, 01 Mar 2024 12:00:30 GMT", want: 30 * time.Second, wantOK: true},
+		{value: "Fri, 01 Mar 2024 11:59:00 GMT", want: 0, wantOK: true},
+		{value: "Friday, 01-Mar-24 12:01:00 GMT", want: time.Minute, wantOK: true},
+		{value: "soon", wantOK: false},
+		{value: "1.5", wantOK: false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// TLSOptions configures how the registry's TLS connections are made
+type TLSOptions struct {
+	Insecure   bool
+	CertFile   string   // Client certificate for mTLS, PEM
+	KeyFile    string   // Private key of CertFile, PEM
+	CAFile     string   // Extra trusted CAs, added to the system pool, PEM
+	Pins       []string // Accepted SPKI hashes, "sha256/<base64>"
+	ServerName string   // SNI and verification name override
+	// Host is the registry host the client certificate, pins and server name
+	// apply to. Other hosts, such as token services, only get the trust
+	// settings. Empty applies them to every host.
+	Host string
+}
+
+// Config returns the TLS configuration for connections to the registry
+func (o TLSOptions) Config() (*tls.Config, error) {
+	cfg, err := o.TrustConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg.ServerName = o.ServerName
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		if o.CertFile == "" || o.KeyFile == "" {
+			return nil, errors.New("a client certificate needs both a certificate and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(o.Pins) > 0 {
+		pins := make(map[string]bool)
+		for _, pin := range o.Pins {
+			hash, err := parsePin(pin)
+			if err != nil {
+				return nil, err
+			}
+			pins[hash] = true
+		}
+		// VerifyConnection also runs with InsecureSkipVerify, so pinning holds
+		// even when chain verification is disabled
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, cert := range cs.PeerCertificates {
+				if pins[spkiHash(cert)] {
+					return nil
+				}
+			}
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("certificate pinning failed: no peer certificate")
+			}
+			return fmt.Errorf("certificate pinning failed: %s presented sha256/%s", cs.ServerName, spkiHash(cs.PeerCertificates[0]))
+		}
+	}
+	return cfg, nil
+}
+
+// Apply configures the TLS connections of transport. The registry-only
+// settings are applied in DialTLSContext, on top of transport.DialContext, as
+// a single tls.Config cannot tell the registry apart from other hosts.
+// Connections made through transport.Proxy bypass DialTLSContext and only get
+// the trust settings.
+func (o TLSOptions) Apply(transport *http.Transport) error {
+	trust, err := o.TrustConfig()
+	if err != nil {
+		return err
+	}
+	registry, err := o.Config()
+	if err != nil {
+		return err
+	}
+	if o.CertFile == "" && len(o.Pins) == 0 && o.ServerName == "" {
+		transport.TLSClientConfig = trust
+		return nil
+	}
+	if o.Host == "" {
+		transport.TLSClientConfig = registry
+		return nil
+	}
+	transport.TLSClientConfig = trust
+
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	timeout := transport.TLSHandshakeTimeout
+	transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %s: %v", addr, err)
+		}
+		cfg := trust
+		if strings.EqualFold(host, o.Host) {
+			cfg = registry
+		}
+		if cfg.ServerName == "" {
+			cfg = cfg.Clone()
+			cfg.ServerName = host
+		}
+
+		raw, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		conn := tls.Client(raw, cfg)
+		if err := conn.HandshakeContext(ctx); err != nil {
+			raw.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+	return nil
+}
+
+// TrustConfig returns the TLS configuration for hosts other than the
+// registry, such as blob storage and proxies: it keeps the trust settings
+// but not the client certificate, pins or server name
+func (o TLSOptions) TrustConfig() (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: o.Insecure, MinVersion: tls.VersionTLS12}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// parsePin accepts sha256/<base64> and curl's sha256//<base64>
+func parsePin(pin string) (string, error) {
+	hash, ok := strings.CutPrefix(pin, "sha256/")
+	hash = strings.TrimPrefix(hash, "/")
+	if !ok {
+		return "", fmt.Errorf("invalid pin %q, expected sha256/<base64>", pin)
+	}
+	raw, err := base64.StdEncoding.DecodeString(hash)
+	if err != nil || len(raw) != sha256.Size {
+		return "", fmt.Errorf("invalid pin %q, expected a base64 SHA-256 hash", pin)
+	}
+	return hash, nil
+}
+
+// spkiHash returns the base64 SHA-256 of a certificate's public key info
+func spkiHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
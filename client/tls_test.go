@@ -0,0 +1,207 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// tlsServer is a TLS server that records the SNI and whether a client
+// certificate was presented for each handshake
+type tlsServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	sni     []string
+	clients []bool
+}
+
+func newTLSServer(t *testing.T) *tlsServer {
+	t.Helper()
+	s := &tlsServer{}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	s.TLS = &tls.Config{
+		ClientAuth: tls.RequestClientCert,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.sni = append(s.sni, cs.ServerName)
+			s.clients = append(s.clients, len(cs.PeerCertificates) > 0)
+			return nil
+		},
+	}
+	s.StartTLS()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// writePEM writes the server certificate as a CA file and its key pair as a
+// client certificate, so tests need no fixtures
+func (s *tlsServer) writePEM(t *testing.T) (caFile, certFile, keyFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	cert := s.TLS.Certificates[0]
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+
+	caFile = filepath.Join(dir, "ca.pem")
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	for name, data := range map[string][]byte{caFile: certPEM, certFile: certPEM, keyFile: keyPEM} {
+		if err := os.WriteFile(name, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return caFile, certFile, keyFile
+}
+
+// get requests https://host/ with every dial going to the server
+func (s *tlsServer) get(t *testing.T, opts TLSOptions, host string) error {
+	t.Helper()
+	transport := NewTransport(1)
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, s.Listener.Addr().String())
+	}
+	if err := opts.Apply(transport); err != nil {
+		t.Fatal(err)
+	}
+	defer transport.CloseIdleConnections()
+	resp, err := (&http.Client{Transport: transport}).Get("https://" + host + "/")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *tlsServer) last() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.sni) == 0 {
+		return "", false
+	}
+	return s.sni[len(s.sni)-1], s.clients[len(s.clients)-1]
+}
+
+func TestTLSOptionsApply(t *testing.T) {
+	srv := newTLSServer(t)
+	caFile, certFile, keyFile := srv.writePEM(t)
+	pin := "sha256/" + spkiHash(srv.Certificate())
+	otherPin := "sha256//" + "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+
+	tests := []struct {
+		name       string
+		opts       TLSOptions
+		host       string
+		wantErr    bool
+		wantSNI    string
+		wantClient bool
+	}{
+		{
+			name:    "trusted CA",
+			opts:    TLSOptions{CAFile: caFile},
+			host:    "example.com",
+			wantSNI: "example.com",
+		},
+		{
+			name:    "pin match",
+			opts:    TLSOptions{CAFile: caFile, Pins: []string{pin}, Host: "example.com"},
+			host:    "example.com",
+			wantSNI: "example.com",
+		},
+		{
+			name:    "pin mismatch",
+			opts:    TLSOptions{CAFile: caFile, Pins: []string{otherPin}, Host: "example.com"},
+			host:    "example.com",
+			wantErr: true,
+		},
+		{
+			name:    "pin mismatch skipped on another host",
+			opts:    TLSOptions{CAFile: caFile, Pins: []string{otherPin}, Host: "registry.test"},
+			host:    "example.com",
+			wantSNI: "example.com",
+		},
+		{
+			name:    "server name override",
+			opts:    TLSOptions{CAFile: caFile, ServerName: "example.com", Host: "registry.test"},
+			host:    "registry.test",
+			wantSNI: "example.com",
+		},
+		{
+			name:    "server name override skipped on another host",
+			opts:    TLSOptions{CAFile: caFile, ServerName: "registry.test", Host: "registry.test"},
+			host:    "example.com",
+			wantSNI: "example.com",
+		},
+		{
+			name:       "client certificate",
+			opts:       TLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, Host: "example.com"},
+			host:       "example.com",
+			wantSNI:    "example.com",
+			wantClient: true,
+		},
+		{
+			name:    "client certificate withheld from another host",
+			opts:    TLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "example.com", Host: "registry.test"},
+			host:    "example.com",
+			wantSNI: "example.com",
+		},
+		{
+			name:    "untrusted certificate",
+			opts:    TLSOptions{Host: "example.com"},
+			host:    "example.com",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := srv.get(t, tt.opts, tt.host)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("GET https://%s/ succeeded, want an error", tt.host)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GET https://%s/: %v", tt.host, err)
+			}
+			sni, client := srv.last()
+			if sni != tt.wantSNI {
+				t.Errorf("server saw SNI %q, want %q", sni, tt.wantSNI)
+			}
+			if client != tt.wantClient {
+				t.Errorf("client certificate presented = %v, want %v", client, tt.wantClient)
+			}
+		})
+	}
+}
+
+func TestParsePin(t *testing.T) {
+	tests := []struct {
+		pin     string
+		wantErr bool
+	}{
+		{pin: "sha256/47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="},
+		{pin: "sha256//47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="},
+		{pin: "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=", wantErr: true},
+		{pin: "sha256/AAAA", wantErr: true},
+		{pin: "sha256/not base64", wantErr: true},
+	}
+	for _, tt := range tests {
+		if _, err := parsePin(tt.pin); (err != nil) != tt.wantErr {
+			t.Errorf("parsePin(%q) error = %v, want error %v", tt.pin, err, tt.wantErr)
+		}
+	}
+}
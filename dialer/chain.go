@@ -0,0 +1,90 @@
+package dialer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// FromURL returns a dialer for a single proxy that reaches it through
+// forward, or directly when forward is nil. Credentials are taken from the
+// URL; for SOCKS4 the username is sent as the user ID, and hostnames are
+// resolved with resolver when it is set.
+func FromURL(proxyURL *url.URL, tlsConfig *tls.Config, forward ContextDialer, resolver *Resolver) (ContextDialer, error) {
+	username := proxyURL.User.Username()
+	password, _ := proxyURL.User.Password()
+	addr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		addr += ":" + defaultPort(proxyURL.Scheme)
+	}
+
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		return NewSOCKS5(addr, username, password, forward), nil
+	case "socks4", "socks4a":
+		d := NewSOCKS4(addr, username, proxyURL.Scheme == "socks4a", forward)
+		d.Resolver = resolver
+		return d, nil
+	case "http", "https":
+		d := NewHTTPConnect(addr, username, password, forward)
+		if proxyURL.Scheme == "https" {
+			if tlsConfig == nil {
+				tlsConfig = &tls.Config{}
+			}
+			d.TLSConfig = tlsConfig
+		}
+		return d, nil
+	}
+	return nil, fmt.Errorf("unsupported proxy scheme: %s", proxyURL.Scheme)
+}
+
+func defaultPort(scheme string) string {
+	switch scheme {
+	case "http":
+		return "80"
+	case "https":
+		return "443"
+	}
+	return "1080"
+}
+
+// ParseChain splits a comma-separated list of proxy URLs, ordered from the
+// first hop to the last
+func ParseChain(spec string) ([]*url.URL, error) {
+	var hops []*url.URL
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		u, err := url.Parse(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %s: %v", part, err)
+		}
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %s: missing host", part)
+		}
+		hops = append(hops, u)
+	}
+	if len(hops) == 0 {
+		return nil, fmt.Errorf("no proxy given")
+	}
+	return hops, nil
+}
+
+// Chain returns a dialer that reaches the target through every hop in
+// order: the first proxy is dialed through forward, or directly when it is
+// nil, and each following proxy is reached through the tunnel of the
+// previous one. SOCKS4 hops resolve hostnames with resolver.
+func Chain(hops []*url.URL, tlsConfig *tls.Config, forward ContextDialer, resolver *Resolver) (ContextDialer, error) {
+	d := forward
+	for _, hop := range hops {
+		next, err := FromURL(hop, tlsConfig, d, resolver)
+		if err != nil {
+			return nil, err
+		}
+		d = next
+	}
+	return d, nil
+}
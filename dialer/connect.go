@@ -0,0 +1,124 @@
+package dialer
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HTTPConnect opens a tunnel through an HTTP proxy with the CONNECT method.
+// With TLSConfig set, the connection to the proxy itself uses TLS, as for
+// https:// proxies.
+type HTTPConnect struct {
+	ProxyAddr        string
+	Username         string
+	Password         string
+	TLSConfig        *tls.Config
+	Header           http.Header   // Extra headers sent with CONNECT, e.g. User-Agent
+	Forward          ContextDialer // Dialer used to reach the proxy itself
+	HandshakeTimeout time.Duration
+}
+
+// NewHTTPConnect returns an HTTP CONNECT dialer that reaches the proxy
+// through forward, or directly when forward is nil
+func NewHTTPConnect(proxyAddr, username, password string, forward ContextDialer) *HTTPConnect {
+	if forward == nil {
+		forward = &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 60 * time.Second}
+	}
+	return &HTTPConnect{
+		ProxyAddr:        proxyAddr,
+		Username:         username,
+		Password:         password,
+		Forward:          forward,
+		HandshakeTimeout: 10 * time.Second,
+	}
+}
+
+// DialContext connects to addr through the proxy
+func (d *HTTPConnect) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("HTTP proxy %s: unsupported network %s", d.ProxyAddr, network)
+	}
+	conn, err := d.Forward.DialContext(ctx, "tcp", d.ProxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to HTTP proxy %s: %v", d.ProxyAddr, err)
+	}
+	if d.TLSConfig != nil {
+		cfg := d.TLSConfig.Clone()
+		if cfg.ServerName == "" {
+			cfg.ServerName, _, _ = net.SplitHostPort(d.ProxyAddr)
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake with HTTP proxy %s failed: %v", d.ProxyAddr, err)
+		}
+		conn = tlsConn
+	}
+
+	var tunnel net.Conn
+	err = handshake(ctx, conn, d.HandshakeTimeout, func() error {
+		var err error
+		tunnel, err = d.connect(conn, addr)
+		return err
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("HTTP proxy %s: %v", d.ProxyAddr, err)
+	}
+	return tunnel, nil
+}
+
+func (d *HTTPConnect) connect(conn net.Conn, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	for k, v := range d.Header {
+		req.Header[k] = v
+	}
+	if d.Username != "" || d.Password != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(d.Username + ":" + d.Password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("failed to send CONNECT for %s: %v", addr, err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CONNECT reply for %s: %v", addr, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusProxyAuthRequired {
+		return nil, fmt.Errorf("proxy authentication required (%s)", resp.Header.Get("Proxy-Authenticate"))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CONNECT to %s failed: %s", addr, resp.Status)
+	}
+
+	// Keep any tunnelled bytes the reader already pulled off the connection
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads through a bufio.Reader that may hold early data
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
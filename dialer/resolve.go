@@ -0,0 +1,100 @@
+package dialer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Resolver maps the host of a dial address to an IP address using curl
+// style overrides first and then, when set, a custom DNS resolver. Without
+// either it leaves addresses untouched, so proxies keep resolving remotely.
+type Resolver struct {
+	Overrides map[string]string // "host:port" or "host:*" to IP address
+	DNS       *net.Resolver
+}
+
+// ParseResolve parses -resolve entries of the form host:port:address, where
+// port may be * and an IPv6 address may be written in brackets
+func ParseResolve(entries []string) (map[string]string, error) {
+	overrides := make(map[string]string)
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid resolve entry %q, expected host:port:address", entry)
+		}
+		addr := strings.TrimSuffix(strings.TrimPrefix(parts[2], "["), "]")
+		if net.ParseIP(addr) == nil {
+			return nil, fmt.Errorf("invalid address %q in resolve entry %q", parts[2], entry)
+		}
+		overrides[strings.ToLower(parts[0])+":"+parts[1]] = addr
+	}
+	return overrides, nil
+}
+
+// NewDNSResolver returns a resolver that queries server (host:port). With a
+// forward dialer, queries use TCP through it, so a DNS server inside the
+// target network can be reached through a proxy.
+func NewDNSResolver(server string, forward ContextDialer) *net.Resolver {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			if forward != nil {
+				return forward.DialContext(ctx, "tcp", server)
+			}
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// Resolve returns addr with its host replaced by the IP address it resolves
+// to, or addr unchanged if neither an override nor a DNS resolver applies
+func (r *Resolver) Resolve(ctx context.Context, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %s: %v", addr, err)
+	}
+	if net.ParseIP(host) != nil {
+		return addr, nil
+	}
+	key := strings.ToLower(host)
+	if ip, ok := r.Overrides[key+":"+port]; ok {
+		return net.JoinHostPort(ip, port), nil
+	}
+	if ip, ok := r.Overrides[key+":*"]; ok {
+		return net.JoinHostPort(ip, port), nil
+	}
+	if r.DNS == nil {
+		return addr, nil
+	}
+	ips, err := r.DNS.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %v", host, err)
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("no addresses for %s", host)
+	}
+	return net.JoinHostPort(ips[0].IP.String(), port), nil
+}
+
+// ResolvingDialer resolves the target address with Resolver before handing
+// it to Forward. The HTTP Host header and TLS SNI still use the original
+// hostname because they come from the request URL, not the dial address.
+type ResolvingDialer struct {
+	Resolver *Resolver
+	Forward  ContextDialer
+}
+
+// DialContext resolves addr and dials the result
+func (d *ResolvingDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	resolved, err := d.Resolver.Resolve(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return d.Forward.DialContext(ctx, network, resolved)
+}
@@ -0,0 +1,94 @@
+package dialer
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestParseResolve(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "none",
+			entries: nil,
+			want:    map[string]string{},
+		},
+		{
+			name:    "IPv4 and wildcard port",
+			entries: []string{"Registry.Internal:443:10.0.0.5", "mirror:*:10.0.0.6"},
+			want:    map[string]string{"registry.internal:443": "10.0.0.5", "mirror:*": "10.0.0.6"},
+		},
+		{
+			name:    "IPv6 with and without brackets",
+			entries: []string{"a:5000:[fd00::1]", "b:5000:fd00::2"},
+			want:    map[string]string{"a:5000": "fd00::1", "b:5000": "fd00::2"},
+		},
+		{
+			name:    "later entries win",
+			entries: []string{"a:443:10.0.0.1", "a:443:10.0.0.2"},
+			want:    map[string]string{"a:443": "10.0.0.2"},
+		},
+		{name: "missing address", entries: []string{"a:443"}, wantErr: true},
+		{name: "empty host", entries: []string{":443:10.0.0.1"}, wantErr: true},
+		{name: "empty port", entries: []string{"a::10.0.0.1"}, wantErr: true},
+		{name: "hostname address", entries: []string{"a:443:b.example.com"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseResolve(tt.entries)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseResolve(%q) = %v, want an error", tt.entries, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseResolve(%q): %v", tt.entries, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseResolve(%q) = %v, want %v", tt.entries, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolverOverrides(t *testing.T) {
+	r := &Resolver{Overrides: map[string]string{
+		"registry.internal:443": "10.0.0.5",
+		"registry.internal:*":   "10.0.0.9",
+		"v6.internal:*":         "fd00::1",
+	}}
+	tests := []struct {
+		addr    string
+		want    string
+		wantErr bool
+	}{
+		{addr: "registry.internal:443", want: "10.0.0.5:443"},
+		{addr: "REGISTRY.internal:5000", want: "10.0.0.9:5000"},
+		{addr: "v6.internal:443", want: "[fd00::1]:443"},
+		{addr: "other.internal:443", want: "other.internal:443"},
+		{addr: "192.0.2.1:443", want: "192.0.2.1:443"},
+		{addr: "registry.internal", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := r.Resolve(context.Background(), tt.addr)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Resolve(%q) = %q, want an error", tt.addr, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Resolve(%q): %v", tt.addr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Resolve(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package dialer
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	socks4Version    = 0x04
+	socks4CmdConnect = 0x01
+	socks4Granted    = 0x5a
+)
+
+// socks4Replies maps SOCKS4 reply codes to readable errors
+var socks4Replies = map[byte]string{
+	0x5b: "request rejected or failed",
+	0x5c: "request rejected because the proxy cannot reach identd on the client",
+	0x5d: "request rejected because identd reported a different user ID",
+}
+
+// SOCKS4 opens a tunnelled connection through a SOCKS4 proxy for every dial.
+// SOCKS4 only carries IPv4 addresses, so hostnames are resolved locally,
+// with Resolver when set, unless RemoteDNS selects the SOCKS4a extension.
+type SOCKS4 struct {
+	ProxyAddr        string
+	UserID           string
+	RemoteDNS        bool          // SOCKS4a: let the proxy resolve hostnames
+	Forward          ContextDialer // Dialer used to reach the proxy itself
+	Resolver         *Resolver     // Overrides and DNS server for local resolution
+	HandshakeTimeout time.Duration
+}
+
+// NewSOCKS4 returns a SOCKS4 or SOCKS4a dialer that reaches the proxy through
+// forward, or directly when forward is nil
+func NewSOCKS4(proxyAddr, userID string, remoteDNS bool, forward ContextDialer) *SOCKS4 {
+	if forward == nil {
+		forward = &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 60 * time.Second}
+	}
+	return &SOCKS4{
+		ProxyAddr:        proxyAddr,
+		UserID:           userID,
+		RemoteDNS:        remoteDNS,
+		Forward:          forward,
+		HandshakeTimeout: 10 * time.Second,
+	}
+}
+
+// DialContext connects to addr through the proxy
+func (d *SOCKS4) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" {
+		return nil, fmt.Errorf("SOCKS4 proxy %s: unsupported network %s", d.ProxyAddr, network)
+	}
+	req, err := d.request(ctx, addr)
+	if err != nil {
+		return nil, fmt.Errorf("SOCKS4 proxy %s: %v", d.ProxyAddr, err)
+	}
+	conn, err := d.Forward.DialContext(ctx, "tcp", d.ProxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SOCKS4 proxy %s: %v", d.ProxyAddr, err)
+	}
+	if err := handshake(ctx, conn, d.HandshakeTimeout, func() error { return d.connect(conn, addr, req) }); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS4 proxy %s: %v", d.ProxyAddr, err)
+	}
+	return conn, nil
+}
+
+// request builds the CONNECT request for addr
+func (d *SOCKS4) request(ctx context.Context, addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target address %s: %v", addr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port in %s", addr)
+	}
+
+	var ip4 net.IP
+	var domain string
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 = ip.To4(); ip4 == nil {
+			return nil, fmt.Errorf("SOCKS4 does not support IPv6 target %s", host)
+		}
+	} else if d.RemoteDNS {
+		// SOCKS4a marks a hostname with the invalid address 0.0.0.x
+		ip4 = net.IPv4(0, 0, 0, 1).To4()
+		domain = host
+	} else if ip4, err = d.lookup(ctx, host, portStr); err != nil {
+		return nil, err
+	}
+
+	req := []byte{socks4Version, socks4CmdConnect}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	req = append(req, ip4...)
+	req = append(req, d.UserID...)
+	req = append(req, 0x00)
+	if domain != "" {
+		req = append(req, domain...)
+		req = append(req, 0x00)
+	}
+	return req, nil
+}
+
+// lookup resolves host to an IPv4 address with the -resolve overrides and
+// DNS server of Resolver, falling back to the system resolver
+func (d *SOCKS4) lookup(ctx context.Context, host, port string) (net.IP, error) {
+	dns := net.DefaultResolver
+	if d.Resolver != nil {
+		resolved, err := d.Resolver.Resolve(ctx, net.JoinHostPort(host, port))
+		if err != nil {
+			return nil, err
+		}
+		resolvedHost, _, _ := net.SplitHostPort(resolved)
+		if ip := net.ParseIP(resolvedHost); ip != nil {
+			if ip.To4() == nil {
+				return nil, fmt.Errorf("SOCKS4 does not support IPv6 target %s for %s", ip, host)
+			}
+			return ip.To4(), nil
+		}
+		if d.Resolver.DNS != nil {
+			dns = d.Resolver.DNS
+		}
+	}
+	addrs, err := dns.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %v", host, err)
+	}
+	for _, a := range addrs {
+		if ip4 := a.IP.To4(); ip4 != nil {
+			return ip4, nil
+		}
+	}
+	return nil, fmt.Errorf("no IPv4 address for %s", host)
+}
+
+func (d *SOCKS4) connect(conn net.Conn, addr string, req []byte) error {
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("failed to send connect request for %s: %v", addr, err)
+	}
+	// The reply is always 8 bytes: version, status, port and address
+	resp := make([]byte, 8)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return fmt.Errorf("failed to read connect reply for %s: %v", addr, err)
+	}
+	if resp[0] != 0x00 {
+		return errors.New("invalid reply, the proxy may not speak SOCKS4")
+	}
+	if resp[1] != socks4Granted {
+		if msg, ok := socks4Replies[resp[1]]; ok {
+			return fmt.Errorf("connect to %s failed: %s", addr, msg)
+		}
+		return fmt.Errorf("connect to %s failed with code %d", addr, resp[1])
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package dialer
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestSOCKS4Request(t *testing.T) {
+	resolver := &Resolver{Overrides: map[string]string{
+		"registry.internal:443": "10.0.0.5",
+		"v6.internal:*":         "fd00::1",
+	}}
+	tests := []struct {
+		name      string
+		addr      string
+		remoteDNS bool
+		resolver  *Resolver
+		want      []byte
+		wantErr   bool
+	}{
+		{
+			name: "IPv4 target",
+			addr: "192.0.2.1:5000",
+			want: []byte{4, 1, 0x13, 0x88, 192, 0, 2, 1, 'u', 0},
+		},
+		{
+			name:     "resolve override",
+			addr:     "registry.internal:443",
+			resolver: resolver,
+			want:     []byte{4, 1, 0x01, 0xbb, 10, 0, 0, 5, 'u', 0},
+		},
+		{
+			name:      "SOCKS4a sends the hostname",
+			addr:      "registry.internal:443",
+			remoteDNS: true,
+			resolver:  resolver,
+			want:      append([]byte{4, 1, 0x01, 0xbb, 0, 0, 0, 1, 'u', 0}, "registry.internal\x00"...),
+		},
+		{
+			name:     "IPv6 override",
+			addr:     "v6.internal:443",
+			resolver: resolver,
+			wantErr:  true,
+		},
+		{
+			name:    "IPv6 target",
+			addr:    "[fd00::1]:443",
+			wantErr: true,
+		},
+		{
+			name:    "invalid port",
+			addr:    "192.0.2.1:http",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewSOCKS4("127.0.0.1:1080", "u", tt.remoteDNS, nil)
+			d.Resolver = tt.resolver
+			got, err := d.request(context.Background(), tt.addr)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("request(%q) = %v, want an error", tt.addr, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("request(%q): %v", tt.addr, err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("request(%q) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package dialer
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// ContextDialer is implemented by net.Dialer and by every proxy dialer, so
+// dialers can be stacked and plugged into http.Transport.DialContext
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+const (
+	socks5Version = 0x05
+
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+	socks5AuthNoAccept = 0xff
+
+	socks5CmdConnect = 0x01
+
+	socks5AddrIPv4   = 0x01
+	socks5AddrDomain = 0x03
+	socks5AddrIPv6   = 0x04
+)
+
+// socks5Replies maps SOCKS5 reply codes to readable errors
+var socks5Replies = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// SOCKS5 opens a fresh tunnelled connection through a SOCKS5 proxy for every
+// dial. Hostnames are sent to the proxy unresolved, like socks5h.
+type SOCKS5 struct {
+	ProxyAddr        string
+	Username         string
+	Password         string
+	Forward          ContextDialer // Dialer used to reach the proxy itself
+	HandshakeTimeout time.Duration
+}
+
+// NewSOCKS5 returns a SOCKS5 dialer that reaches the proxy through forward,
+// or directly when forward is nil
+func NewSOCKS5(proxyAddr, username, password string, forward ContextDialer) *SOCKS5 {
+	if forward == nil {
+		forward = &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 60 * time.Second}
+	}
+	return &SOCKS5{
+		ProxyAddr:        proxyAddr,
+		Username:         username,
+		Password:         password,
+		Forward:          forward,
+		HandshakeTimeout: 10 * time.Second,
+	}
+}
+
+// DialContext connects to addr through the proxy
+func (d *SOCKS5) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("SOCKS5 proxy %s: unsupported network %s", d.ProxyAddr, network)
+	}
+	conn, err := d.Forward.DialContext(ctx, "tcp", d.ProxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SOCKS5 proxy %s: %v", d.ProxyAddr, err)
+	}
+	if err := handshake(ctx, conn, d.HandshakeTimeout, func() error { return d.connect(conn, addr) }); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS5 proxy %s: %v", d.ProxyAddr, err)
+	}
+	return conn, nil
+}
+
+// handshake runs fn with a deadline derived from ctx and timeout, and aborts
+// it by closing the connection if ctx is cancelled. The watcher has exited
+// before the deadline is cleared, so a late cancellation cannot leave an
+// expired deadline on a connection that is handed out.
+func handshake(ctx context.Context, conn net.Conn, timeout time.Duration, fn func() error) error {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	err := fn()
+	close(done)
+	<-stopped
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	conn.SetDeadline(time.Time{})
+	return err
+}
+
+func (d *SOCKS5) connect(conn net.Conn, addr string) error {
+	// Method negotiation
+	methods := []byte{socks5AuthNone}
+	if d.Username != "" || d.Password != "" {
+		methods = append(methods, socks5AuthPassword)
+	}
+	if _, err := conn.Write(append([]byte{socks5Version, byte(len(methods))}, methods...)); err != nil {
+		return fmt.Errorf("failed to send auth methods: %v", err)
+	}
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return fmt.Errorf("failed to read auth method: %v", err)
+	}
+	if resp[0] != socks5Version {
+		return fmt.Errorf("unexpected protocol version %d", resp[0])
+	}
+	switch resp[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if err := d.authenticate(conn); err != nil {
+			return err
+		}
+	case socks5AuthNoAccept:
+		return errors.New("no acceptable authentication method")
+	default:
+		return fmt.Errorf("unsupported authentication method %d", resp[1])
+	}
+
+	// CONNECT request
+	req, err := socks5Address(addr)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(append([]byte{socks5Version, socks5CmdConnect, 0x00}, req...)); err != nil {
+		return fmt.Errorf("failed to send connect request for %s: %v", addr, err)
+	}
+
+	// The reply carries the bound address, whose length depends on its type
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("failed to read connect reply for %s: %v", addr, err)
+	}
+	if header[1] != 0x00 {
+		if msg, ok := socks5Replies[header[1]]; ok {
+			return fmt.Errorf("connect to %s failed: %s", addr, msg)
+		}
+		return fmt.Errorf("connect to %s failed with code %d", addr, header[1])
+	}
+	var boundLen int
+	switch header[3] {
+	case socks5AddrIPv4:
+		boundLen = net.IPv4len
+	case socks5AddrIPv6:
+		boundLen = net.IPv6len
+	case socks5AddrDomain:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return fmt.Errorf("failed to read bound address: %v", err)
+		}
+		boundLen = int(l[0])
+	default:
+		return fmt.Errorf("unknown bound address type %d", header[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, boundLen+2)); err != nil {
+		return fmt.Errorf("failed to read bound address: %v", err)
+	}
+	return nil
+}
+
+// authenticate performs RFC 1929 username/password authentication
+func (d *SOCKS5) authenticate(conn net.Conn) error {
+	if len(d.Username) > 255 || len(d.Password) > 255 {
+		return errors.New("username and password must be at most 255 bytes")
+	}
+	req := []byte{0x01, byte(len(d.Username))}
+	req = append(req, d.Username...)
+	req = append(req, byte(len(d.Password)))
+	req = append(req, d.Password...)
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("failed to send credentials: %v", err)
+	}
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return fmt.Errorf("failed to read authentication reply: %v", err)
+	}
+	if resp[1] != 0x00 {
+		return errors.New("authentication failed")
+	}
+	return nil
+}
+
+// socks5Address encodes host:port as ATYP, address and port
+func socks5Address(addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target address %s: %v", addr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port in %s", addr)
+	}
+
+	var b []byte
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append([]byte{socks5AddrIPv4}, ip4...)
+		} else {
+			b = append([]byte{socks5AddrIPv6}, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("hostname too long: %s", host)
+		}
+		b = append([]byte{socks5AddrDomain, byte(len(host))}, host...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
@@ -0,0 +1,220 @@
+package dialer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSOCKS5Address(t *testing.T) {
+	tests := []struct {
+		addr    string
+		want    []byte
+		wantErr bool
+	}{
+		{
+			addr: "192.0.2.1:5000",
+			want: []byte{socks5AddrIPv4, 192, 0, 2, 1, 0x13, 0x88},
+		},
+		{
+			addr: "[::ffff:192.0.2.1]:443",
+			want: []byte{socks5AddrIPv4, 192, 0, 2, 1, 0x01, 0xbb},
+		},
+		{
+			addr: "[fd00::1]:443",
+			want: []byte{socks5AddrIPv6, 0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x01, 0xbb},
+		},
+		{
+			addr: "registry.internal:443",
+			want: append(append([]byte{socks5AddrDomain, 17}, "registry.internal"...), 0x01, 0xbb),
+		},
+		{addr: strings.Repeat("a", 256) + ":443", wantErr: true},
+		{addr: "registry.internal", wantErr: true},
+		{addr: "registry.internal:65536", wantErr: true},
+		{addr: "registry.internal:https", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := socks5Address(tt.addr)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("socks5Address(%q) = %v, want an error", tt.addr, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("socks5Address(%q): %v", tt.addr, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("socks5Address(%q) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+// fakeSOCKS5 answers one method negotiation and CONNECT on conn with reply,
+// then writes "ok" so the client can check it consumed the whole reply
+func fakeSOCKS5(conn net.Conn, method byte, reply []byte) {
+	defer conn.Close()
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, greeting[1])); err != nil {
+		return
+	}
+	conn.Write([]byte{socks5Version, method})
+	if method == socks5AuthPassword {
+		auth := make([]byte, 2)
+		io.ReadFull(conn, auth)
+		io.ReadFull(conn, make([]byte, auth[1]))
+		io.ReadFull(conn, auth[:1])
+		io.ReadFull(conn, make([]byte, auth[0]))
+		conn.Write([]byte{0x01, 0x00})
+	}
+	if method == socks5AuthNoAccept {
+		return
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	n := 0
+	switch header[3] {
+	case socks5AddrIPv4:
+		n = net.IPv4len
+	case socks5AddrIPv6:
+		n = net.IPv6len
+	case socks5AddrDomain:
+		l := make([]byte, 1)
+		io.ReadFull(conn, l)
+		n = int(l[0])
+	}
+	io.ReadFull(conn, make([]byte, n+2))
+	conn.Write(append(reply, "ok"...))
+}
+
+func TestSOCKS5Connect(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		method   byte
+		reply    []byte
+		wantErr  string
+	}{
+		{
+			name:  "IPv4 bound address",
+			reply: []byte{5, 0, 0, socks5AddrIPv4, 10, 0, 0, 1, 0x04, 0x38},
+		},
+		{
+			name:  "IPv6 bound address",
+			reply: append(append([]byte{5, 0, 0, socks5AddrIPv6}, net.ParseIP("fd00::1")...), 0x04, 0x38),
+		},
+		{
+			name:  "domain bound address",
+			reply: append(append([]byte{5, 0, 0, socks5AddrDomain, 9}, "proxy.lan"...), 0x04, 0x38),
+		},
+		{
+			name:     "username and password",
+			username: "user",
+			method:   socks5AuthPassword,
+			reply:    []byte{5, 0, 0, socks5AddrIPv4, 10, 0, 0, 1, 0x04, 0x38},
+		},
+		{
+			name:    "no acceptable method",
+			method:  socks5AuthNoAccept,
+			wantErr: "no acceptable authentication method",
+		},
+		{
+			name:    "connection refused",
+			reply:   []byte{5, 0x05, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0},
+			wantErr: "connection refused",
+		},
+		{
+			name:    "ruleset",
+			reply:   []byte{5, 0x02, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0},
+			wantErr: "not allowed by ruleset",
+		},
+		{
+			name:    "unknown reply code",
+			reply:   []byte{5, 0x42, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0},
+			wantErr: "failed with code 66",
+		},
+		{
+			name:    "unknown bound address type",
+			reply:   []byte{5, 0, 0, 0x09},
+			wantErr: "unknown bound address type 9",
+		},
+		{
+			name:    "truncated bound address",
+			reply:   []byte{5, 0, 0, socks5AddrIPv6, 0xfd},
+			wantErr: "failed to read bound address",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			go fakeSOCKS5(server, tt.method, tt.reply)
+
+			d := NewSOCKS5("proxy:1080", tt.username, "secret", nil)
+			err := handshake(context.Background(), client, time.Second, func() error {
+				return d.connect(client, "registry.internal:443")
+			})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("connect() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("connect(): %v", err)
+			}
+			got := make([]byte, 2)
+			if _, err := io.ReadFull(client, got); err != nil || string(got) != "ok" {
+				t.Errorf("read after reply = %q, %v, want \"ok\"", got, err)
+			}
+		})
+	}
+}
+
+// deadlineConn records the last deadline set on a connection
+type deadlineConn struct {
+	net.Conn
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func (c *deadlineConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func TestHandshakeClearsDeadline(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		client, server := net.Pipe()
+		conn := &deadlineConn{Conn: client}
+		ctx, cancel := context.WithCancel(context.Background())
+		if err := handshake(ctx, conn, time.Second, func() error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+		// A cancellation after the handshake must not reach the connection
+		cancel()
+		time.Sleep(100 * time.Microsecond)
+		conn.mu.Lock()
+		deadline := conn.deadline
+		conn.mu.Unlock()
+		if !deadline.IsZero() {
+			t.Fatalf("deadline %v set on the connection after the handshake returned", deadline)
+		}
+		client.Close()
+		server.Close()
+	}
+}
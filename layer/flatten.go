@@ -0,0 +1,141 @@
+package layer
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	opaqueWhiteout = ".wh..wh..opq"
+)
+
+// Source identifies a layer blob on disk
+type Source struct {
+	Digest string
+	File   string
+}
+
+// ExtractFunc inspects a file of a layer and returns the value to keep for
+// it, or nil if the file is not interesting
+type ExtractFunc func(name string, hdr *tar.Header, r io.Reader) (interface{}, error)
+
+// Change is a single filesystem operation performed by a layer
+type Change struct {
+	Path   string
+	Delete bool        // Whiteout removing Path and everything below it
+	Opaque bool        // Opaque whiteout hiding the lower contents of directory Path
+	Value  interface{} // Extracted value, nil if the file was not of interest
+}
+
+// ReadChanges streams a layer and records the changes it makes, in tar order.
+// Directories are not recorded.
+func ReadChanges(r io.Reader, extract ExtractFunc) ([]Change, error) {
+	var changes []Change
+	err := Walk(r, func(hdr *tar.Header, r io.Reader) error {
+		name := CleanPath(hdr.Name)
+		dir, base := path.Split(name)
+		dir = path.Clean(dir)
+
+		switch {
+		case base == opaqueWhiteout:
+			changes = append(changes, Change{Path: dir, Opaque: true})
+		case strings.HasPrefix(base, whiteoutPrefix):
+			changes = append(changes, Change{Path: path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)), Delete: true})
+		case hdr.Typeflag != tar.TypeDir:
+			value, err := extract(name, hdr, r)
+			if err != nil {
+				return fmt.Errorf("failed to process %s: %v", name, err)
+			}
+			changes = append(changes, Change{Path: name, Value: value})
+		}
+		return nil
+	})
+	return changes, err
+}
+
+// Tree is a merged filesystem view built by applying layers in order
+type Tree struct {
+	Files map[string]interface{}
+}
+
+// NewTree returns an empty filesystem
+func NewTree() *Tree {
+	return &Tree{Files: make(map[string]interface{})}
+}
+
+// Apply merges the changes of one layer on top of the tree and returns the
+// paths that its whiteouts removed
+func (t *Tree) Apply(changes []Change) []string {
+	// Paths added by the current layer are not affected by its own whiteouts
+	added := make(map[string]bool)
+	var removed []string
+	for _, c := range changes {
+		switch {
+		case c.Opaque:
+			for p := range t.Files {
+				if isUnder(p, c.Path) && !added[p] {
+					delete(t.Files, p)
+					removed = append(removed, p)
+				}
+			}
+		case c.Delete:
+			for p := range t.Files {
+				if p == c.Path || isUnder(p, c.Path) {
+					delete(t.Files, p)
+					removed = append(removed, p)
+				}
+			}
+		default:
+			delete(t.Files, c.Path)
+			if c.Value != nil {
+				t.Files[c.Path] = c.Value
+				added[c.Path] = true
+			}
+		}
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+// Paths returns the paths of the tree in sorted order
+func (t *Tree) Paths() []string {
+	paths := make([]string, 0, len(t.Files))
+	for p := range t.Files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Flatten applies the layers in order, honouring whiteouts, and returns
+// the extracted values of the files that survive in the final filesystem
+func Flatten(layers []Source, extract ExtractFunc) (map[string]interface{}, error) {
+	tree := NewTree()
+	for _, l := range layers {
+		f, err := os.Open(l.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open layer %s: %v", l.File, err)
+		}
+		changes, err := ReadChanges(f, extract)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read layer %s: %v", l.File, err)
+		}
+		tree.Apply(changes)
+	}
+	return tree.Files, nil
+}
+
+// isUnder reports whether p is strictly inside directory dir
+func isUnder(p, dir string) bool {
+	if dir == "/" {
+		return p != "/"
+	}
+	return strings.HasPrefix(p, dir+"/")
+}
@@ -0,0 +1,125 @@
+package layer
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// buildLayer returns a tar stream holding the given entries, where names
+// ending in "/" are directories
+func buildLayer(t *testing.T, names ...string) io.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range names {
+		hdr := &tar.Header{Name: name, Mode: 0644, Typeflag: tar.TypeReg}
+		if name[len(name)-1] == '/' {
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestReadChanges(t *testing.T) {
+	r := buildLayer(t, "./etc/", "./etc/passwd", "etc/.wh.shadow", "var/cache/.wh..wh..opq", "tmp/skip")
+	extract := func(name string, hdr *tar.Header, r io.Reader) (interface{}, error) {
+		if name == "/tmp/skip" {
+			return nil, nil
+		}
+		return name, nil
+	}
+	got, err := ReadChanges(r, extract)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Change{
+		{Path: "/etc/passwd", Value: "/etc/passwd"},
+		{Path: "/etc/shadow", Delete: true},
+		{Path: "/var/cache", Opaque: true},
+		{Path: "/tmp/skip"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadChanges() = %+v, want %+v", got, want)
+	}
+}
+
+func TestTreeApply(t *testing.T) {
+	base := []Change{
+		{Path: "/etc/passwd", Value: "base"},
+		{Path: "/etc/shadow", Value: "base"},
+		{Path: "/var/cache/a", Value: "base"},
+		{Path: "/var/cache/sub/b", Value: "base"},
+		{Path: "/var/cachefile", Value: "base"},
+	}
+	tests := []struct {
+		name        string
+		layer       []Change
+		want        []string
+		wantRemoved []string
+	}{
+		{
+			name:  "no changes",
+			layer: nil,
+			want:  []string{"/etc/passwd", "/etc/shadow", "/var/cache/a", "/var/cache/sub/b", "/var/cachefile"},
+		},
+		{
+			name:        "file whiteout",
+			layer:       []Change{{Path: "/etc/shadow", Delete: true}},
+			want:        []string{"/etc/passwd", "/var/cache/a", "/var/cache/sub/b", "/var/cachefile"},
+			wantRemoved: []string{"/etc/shadow"},
+		},
+		{
+			name:        "directory whiteout removes the subtree only",
+			layer:       []Change{{Path: "/var/cache", Delete: true}},
+			want:        []string{"/etc/passwd", "/etc/shadow", "/var/cachefile"},
+			wantRemoved: []string{"/var/cache/a", "/var/cache/sub/b"},
+		},
+		{
+			name: "opaque whiteout keeps files of the same layer",
+			layer: []Change{
+				{Path: "/var/cache/new", Value: "top"},
+				{Path: "/var/cache", Opaque: true},
+			},
+			want:        []string{"/etc/passwd", "/etc/shadow", "/var/cache/new", "/var/cachefile"},
+			wantRemoved: []string{"/var/cache/a", "/var/cache/sub/b"},
+		},
+		{
+			name:        "whiteout then re-add",
+			layer:       []Change{{Path: "/etc/shadow", Delete: true}, {Path: "/etc/shadow", Value: "top"}},
+			want:        []string{"/etc/passwd", "/etc/shadow", "/var/cache/a", "/var/cache/sub/b", "/var/cachefile"},
+			wantRemoved: []string{"/etc/shadow"},
+		},
+		{
+			name:  "uninteresting overwrite hides the lower file",
+			layer: []Change{{Path: "/etc/passwd"}},
+			want:  []string{"/etc/shadow", "/var/cache/a", "/var/cache/sub/b", "/var/cachefile"},
+		},
+		{
+			name:        "root opaque whiteout",
+			layer:       []Change{{Path: "/", Opaque: true}},
+			want:        []string{},
+			wantRemoved: []string{"/etc/passwd", "/etc/shadow", "/var/cache/a", "/var/cache/sub/b", "/var/cachefile"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := NewTree()
+			tree.Apply(base)
+			if removed := tree.Apply(tt.layer); !reflect.DeepEqual(removed, tt.wantRemoved) {
+				t.Errorf("Apply() removed %v, want %v", removed, tt.wantRemoved)
+			}
+			if got := tree.Paths(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Paths() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package layer
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Actions recorded in a file history
+const (
+	ActionAdded    = "added"
+	ActionModified = "modified"
+	ActionDeleted  = "deleted"
+)
+
+// Event is one layer touching a path
+type Event struct {
+	Layer  int    `json:"layer"` // 1-based position in the manifest
+	Digest string `json:"digest"`
+	Action string `json:"action"`
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// FileHistory lists every layer that touched a path
+type FileHistory struct {
+	Path   string  `json:"path"`
+	Events []Event `json:"events"`
+	// Recoverable is set when the path was deleted or overwritten, but
+	// content it no longer shows is still stored in an earlier layer blob
+	Recoverable bool `json:"recoverable"`
+	// RecoverFrom is the layer holding the last such content
+	RecoverFrom *Event `json:"recoverFrom,omitempty"`
+}
+
+// content is what History keeps for every file of the merged tree
+type content struct {
+	size   int64
+	sha256 string
+}
+
+// History replays the layers in order and returns the history of every path
+// they touched, sorted by path
+func History(layers []Source) ([]FileHistory, error) {
+	tree := NewTree()
+	histories := make(map[string]*FileHistory)
+	record := func(path string, e Event) {
+		h, ok := histories[path]
+		if !ok {
+			h = &FileHistory{Path: path}
+			histories[path] = h
+		}
+		h.Events = append(h.Events, e)
+	}
+
+	for i, l := range layers {
+		f, err := os.Open(l.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open layer %s: %v", l.File, err)
+		}
+		changes, err := ReadChanges(f, hashContent)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read layer %s: %v", l.File, err)
+		}
+
+		// Whiteouts only hide the lower layers, so the deletions of a layer
+		// come before its own additions, even when it re-adds a path
+		existed := make(map[string]bool)
+		for _, c := range changes {
+			if _, ok := tree.Files[c.Path]; ok {
+				existed[c.Path] = true
+			}
+		}
+		removed := tree.Apply(changes)
+		for _, p := range removed {
+			record(p, Event{Layer: i + 1, Digest: l.Digest, Action: ActionDeleted})
+			delete(existed, p)
+		}
+		for _, c := range changes {
+			if c.Delete || c.Opaque {
+				continue
+			}
+			action := ActionAdded
+			if existed[c.Path] {
+				action = ActionModified
+			}
+			existed[c.Path] = true
+			e := Event{Layer: i + 1, Digest: l.Digest, Action: action}
+			if v, ok := c.Value.(content); ok {
+				e.Size, e.SHA256 = v.size, v.sha256
+			}
+			record(c.Path, e)
+		}
+	}
+
+	result := make([]FileHistory, 0, len(histories))
+	for path, h := range histories {
+		// The latest content the final filesystem no longer shows is where
+		// deleted or overwritten data can be recovered from
+		final := ""
+		if v, exists := tree.Files[path]; exists {
+			final = v.(content).sha256
+		}
+		for j := len(h.Events) - 1; j >= 0; j-- {
+			if sha := h.Events[j].SHA256; sha != "" && sha != final {
+				e := h.Events[j]
+				h.Recoverable = true
+				h.RecoverFrom = &e
+				break
+			}
+		}
+		result = append(result, *h)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result, nil
+}
+
+// hashContent records the size and digest of regular files; other entries
+// such as symlinks are tracked without content
+func hashContent(name string, hdr *tar.Header, r io.Reader) (interface{}, error) {
+	if hdr.Typeflag != tar.TypeReg {
+		return content{size: hdr.Size}, nil
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return nil, err
+	}
+	return content{size: hdr.Size, sha256: "sha256:" + hex.EncodeToString(hash.Sum(nil))}, nil
+}
@@ -0,0 +1,126 @@
+package layer
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// entry is a tar entry of a test layer; whiteouts have no body
+type entry struct {
+	name string
+	body string
+}
+
+// writeLayer writes the entries as an uncompressed layer file and returns
+// its Source
+func writeLayer(t *testing.T, digest string, entries ...entry) Source {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), digest+".tar")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return Source{Digest: digest, File: file}
+}
+
+func sha(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestHistory(t *testing.T) {
+	layers := []Source{
+		writeLayer(t, "l1",
+			entry{"etc/app.conf", "v1"},
+			entry{"root/.aws/credentials", "secret"},
+			entry{"usr/bin/tool", "old"},
+			entry{"etc/same", "same"},
+		),
+		writeLayer(t, "l2",
+			// Whiteout and re-add in the same layer
+			entry{"etc/.wh.app.conf", ""},
+			entry{"etc/app.conf", "v2"},
+			entry{"etc/.wh.same", ""},
+			entry{"etc/same", "same"},
+			entry{"root/.aws/.wh.credentials", ""},
+			entry{"usr/bin/tool", "new"},
+		),
+	}
+	histories, err := History(layers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]FileHistory)
+	for _, h := range histories {
+		got[h.Path] = h
+	}
+
+	tests := []struct {
+		path        string
+		actions     []string
+		recoverFrom string // SHA256 of the recoverable content, empty when none
+	}{
+		{
+			path:        "/etc/app.conf",
+			actions:     []string{ActionAdded, ActionDeleted, ActionAdded},
+			recoverFrom: sha("v1"),
+		},
+		{
+			path:    "/etc/same",
+			actions: []string{ActionAdded, ActionDeleted, ActionAdded},
+		},
+		{
+			path:        "/root/.aws/credentials",
+			actions:     []string{ActionAdded, ActionDeleted},
+			recoverFrom: sha("secret"),
+		},
+		{
+			path:        "/usr/bin/tool",
+			actions:     []string{ActionAdded, ActionModified},
+			recoverFrom: sha("old"),
+		},
+	}
+	if len(got) != len(tests) {
+		t.Errorf("History() returned %d paths, want %d", len(got), len(tests))
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			h, ok := got[tt.path]
+			if !ok {
+				t.Fatalf("no history for %s", tt.path)
+			}
+			var actions []string
+			for _, e := range h.Events {
+				actions = append(actions, e.Action)
+			}
+			if !reflect.DeepEqual(actions, tt.actions) {
+				t.Fatalf("actions = %v, want %v", actions, tt.actions)
+			}
+			if h.Recoverable != (tt.recoverFrom != "") {
+				t.Fatalf("Recoverable = %v, want %v", h.Recoverable, tt.recoverFrom != "")
+			}
+			if tt.recoverFrom != "" && (h.RecoverFrom.SHA256 != tt.recoverFrom || h.RecoverFrom.Digest != "l1") {
+				t.Errorf("RecoverFrom = %+v, want %s from l1", h.RecoverFrom, tt.recoverFrom)
+			}
+		})
+	}
+}
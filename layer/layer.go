@@ -0,0 +1,64 @@
+package layer
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// WalkFunc is called for every entry of a layer tarball. The reader is only
+// valid until the function returns.
+type WalkFunc func(hdr *tar.Header, r io.Reader) error
+
+// Walk streams a layer blob, transparently handling gzip compression, and
+// calls fn for each tar entry in order
+func Walk(r io.Reader, fn WalkFunc) error {
+	br := bufio.NewReader(r)
+	var src io.Reader = br
+
+	// Sniff the gzip magic bytes instead of trusting the media type
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("failed to open gzip stream: %v", err)
+		}
+		defer gz.Close()
+		src = gz
+	}
+
+	tr := tar.NewReader(src)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar entry: %v", err)
+		}
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// CleanPath normalizes a tar entry name to an absolute, slash-separated path
+func CleanPath(name string) string {
+	return path.Clean("/" + strings.TrimPrefix(name, "./"))
+}
+
+// IsBinary reports whether the given content looks like binary data
+func IsBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	for _, b := range data {
+		if b == 0 {
+			return true
+		}
+	}
+	return false
+}
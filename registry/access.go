@@ -0,0 +1,215 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/fatih/color"
+
+	"dockdiver/client"
+)
+
+// Identity is a named set of credentials checked by BuildAccessMatrix
+type Identity struct {
+	Name string `json:"name"`
+	client.AuthConfig
+}
+
+// LoadIdentities reads a JSON array of identities such as
+// [{"name": "ci", "username": "ci", "password": "secret"}, {"name": "deploy", "bearer": "eyJ..."}].
+// An anonymous identity is added unless the file already has one without
+// credentials.
+func LoadIdentities(path string) ([]Identity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identities: %v", err)
+	}
+	var identities []Identity
+	if err := json.Unmarshal(data, &identities); err != nil {
+		return nil, fmt.Errorf("failed to parse identities %s: %v", path, err)
+	}
+
+	anonymous := false
+	seen := make(map[string]bool)
+	for i, id := range identities {
+		if id.Name == "" {
+			return nil, fmt.Errorf("identity %d in %s has no name", i+1, path)
+		}
+		if seen[id.Name] {
+			return nil, fmt.Errorf("identity %s is listed twice in %s", id.Name, path)
+		}
+		seen[id.Name] = true
+		if id.AuthConfig == (client.AuthConfig{}) {
+			anonymous = true
+		}
+	}
+	if !anonymous && !seen["anonymous"] {
+		identities = append([]Identity{{Name: "anonymous"}}, identities...)
+	}
+	return identities, nil
+}
+
+// Access is what one identity can do with one repository
+type Access struct {
+	Listed bool   `json:"listed"` // Shown by the catalog
+	Pull   bool   `json:"pull"`   // Tags and a manifest could be read
+	Push   bool   `json:"push"`   // A blob upload could be started
+	Error  string `json:"error,omitempty"`
+}
+
+// AccessMatrix records the access of every identity to every repository
+type AccessMatrix struct {
+	Identities   []string                     `json:"identities"`
+	Repositories []string                     `json:"repositories"`
+	Catalog      map[string]bool              `json:"catalog"` // Identities allowed to list the catalog
+	Access       map[string]map[string]Access `json:"access"`  // Repository, then identity
+}
+
+// IdentitySummary counts the repositories an identity can list, pull and push
+type IdentitySummary struct {
+	Name    string
+	Catalog bool
+	Listed  int
+	Pull    int
+	Push    int
+}
+
+// Summary returns the access counts of each identity, in identity order
+func (m *AccessMatrix) Summary() []IdentitySummary {
+	var summaries []IdentitySummary
+	for _, name := range m.Identities {
+		s := IdentitySummary{Name: name, Catalog: m.Catalog[name]}
+		for _, repo := range m.Repositories {
+			a := m.Access[repo][name]
+			if a.Listed {
+				s.Listed++
+			}
+			if a.Pull {
+				s.Pull++
+			}
+			if a.Push {
+				s.Push++
+			}
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries
+}
+
+// BuildAccessMatrix lists the catalog as every identity, then checks pull
+// and push access of every identity to every repository seen by any of
+// them, plus extra repositories such as names found by -brute-repos. Bearer
+// challenges are answered with tokens scoped to each repository, so the
+// matrix reflects what the token service grants. Push is checked by
+// starting a blob upload that is cancelled at once. The catalog is listed
+// with catalogs, or _catalog when it is empty.
+func BuildAccessMatrix(ep Endpoint, identities []Identity, extra []string, catalogs []CatalogSource, cli *client.Client, concurrency int) (*AccessMatrix, error) {
+	warning := color.New(color.FgYellow).SprintFunc()
+
+	m := &AccessMatrix{
+		Catalog: make(map[string]bool),
+		Access:  make(map[string]map[string]Access),
+	}
+	listed := make(map[string]map[string]bool)
+	for _, id := range identities {
+		m.Identities = append(m.Identities, id.Name)
+		listed[id.Name] = make(map[string]bool)
+		repos, err := listRepositoriesReauth(ep, id.AuthConfig, catalogs, cli)
+		if err != nil {
+			fmt.Printf("%s %s cannot list the catalog: %v\n", warning("[!]"), id.Name, err)
+			continue
+		}
+		m.Catalog[id.Name] = true
+		for _, repo := range repos {
+			listed[id.Name][repo] = true
+			m.Access[repo] = nil
+		}
+	}
+	for _, repo := range extra {
+		m.Access[repo] = nil
+	}
+	if len(m.Access) == 0 {
+		return nil, fmt.Errorf("no identity could list the catalog and no repositories were given")
+	}
+	for repo := range m.Access {
+		m.Repositories = append(m.Repositories, repo)
+		m.Access[repo] = make(map[string]Access)
+	}
+	sort.Strings(m.Repositories)
+	fmt.Printf("%s Checking %d identities against %d repositories\n", warning("[!]"), len(identities), len(m.Repositories))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	semaphore := make(chan struct{}, workers(concurrency))
+	for _, id := range identities {
+		for _, repo := range m.Repositories {
+			wg.Add(1)
+			semaphore <- struct{}{}
+			go func(id Identity, repo string) {
+				defer wg.Done()
+				defer func() { <-semaphore }()
+				access := checkAccess(ep, repo, id.AuthConfig, cli)
+				access.Listed = listed[id.Name][repo]
+				mu.Lock()
+				m.Access[repo][id.Name] = access
+				mu.Unlock()
+			}(id, repo)
+		}
+	}
+	wg.Wait()
+	return m, nil
+}
+
+// listRepositoriesReauth lists the catalog, answering a Bearer challenge
+// with a token for the catalog scope
+func listRepositoriesReauth(ep Endpoint, auth client.AuthConfig, catalogs []CatalogSource, cli *client.Client) ([]string, error) {
+	repos, err := ListRepositories(ep, auth, cli, catalogs)
+	if !errors.Is(err, client.ErrUnauthorized) {
+		return repos, err
+	}
+	tokenAuth, authErr := cli.Reauthenticate(err, auth)
+	if authErr != nil {
+		return nil, err
+	}
+	return ListRepositories(ep, tokenAuth, cli, catalogs)
+}
+
+// checkAccess checks whether auth can pull from and push to repo
+func checkAccess(ep Endpoint, repo string, auth client.AuthConfig, cli *client.Client) Access {
+	var access Access
+	tags, err := probeRepository(ep, repo, auth, cli)
+	if err == nil && len(tags) > 0 {
+		_, err = pullManifest(ep, repo, tags[0], auth, cli)
+	}
+	switch {
+	case err == nil && len(tags) > 0:
+		access.Pull = true
+	case err != nil && !errors.Is(err, client.ErrUnauthorized) && !errors.Is(err, client.ErrDenied) && !errors.Is(err, client.ErrNameUnknown):
+		access.Error = err.Error()
+	}
+
+	push, _, err := startUpload(ep, repo, auth, cli)
+	if err != nil && access.Error == "" {
+		access.Error = err.Error()
+	}
+	access.Push = push
+	return access
+}
+
+// pullManifest checks that the manifest of repo:tag can be read, answering a
+// Bearer challenge with a token scoped to the repository
+func pullManifest(ep Endpoint, repo, tag string, auth client.AuthConfig, cli *client.Client) (string, error) {
+	digest, err := headManifest(ep, repo, tag, auth, cli)
+	if !errors.Is(err, client.ErrUnauthorized) {
+		return digest, err
+	}
+	tokenAuth, authErr := cli.Reauthenticate(err, auth)
+	if authErr != nil {
+		return "", err
+	}
+	return headManifest(ep, repo, tag, tokenAuth, cli)
+}
@@ -0,0 +1,89 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"dockdiver/client"
+)
+
+// artifactoryDockerAPI is the path Artifactory serves the registry API of a
+// repository under, followed by the repository key
+const artifactoryDockerAPI = "/artifactory/api/docker/"
+
+// artifactoryCatalog lists repositories through the Artifactory REST API:
+// the docker repository keys, then the _catalog of each served under
+// /artifactory/api/docker/<key>, which answers even when the subdomain or
+// port the registry is reached on does not. Names are returned as the
+// endpoint resolves them: bare when it serves a single key, through its API
+// root or a <key>.host subdomain, and as <key>/<name> otherwise, as the
+// repository path access method expects.
+type artifactoryCatalog struct {
+	opts CatalogOptions
+}
+
+func (artifactoryCatalog) Name() string { return "artifactory" }
+
+func (c artifactoryCatalog) Repositories(ep Endpoint, auth client.AuthConfig, cli Doer) ([]string, error) {
+	base := c.opts.apiBase(ep)
+
+	if key, ok := strings.CutPrefix(ep.BasePath, artifactoryDockerAPI); ok && key != "" {
+		return artifactoryKeyCatalog(base, key, auth, cli)
+	}
+
+	header := http.Header{"Accept": {"application/json"}}
+	resp, err := cli.Do("GET", base+"/artifactory/api/repositories?packageType=docker", auth, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Artifactory repositories: %w", err)
+	}
+	var repositories []struct {
+		Key string `json:"key"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&repositories)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode Artifactory repositories: %v", err)
+	}
+
+	subdomain, _, _ := strings.Cut(ep.Host, ".")
+	for _, r := range repositories {
+		if strings.EqualFold(r.Key, subdomain) {
+			return artifactoryKeyCatalog(base, r.Key, auth, cli)
+		}
+	}
+
+	var names []string
+	var firstErr error
+	for _, r := range repositories {
+		repos, err := artifactoryKeyCatalog(base, r.Key, auth, cli)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		for _, repo := range repos {
+			names = append(names, r.Key+"/"+repo)
+		}
+	}
+	if len(names) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return uniqueSorted(names), nil
+}
+
+// artifactoryKeyCatalog lists the _catalog of one repository key
+func artifactoryKeyCatalog(base, key string, auth client.AuthConfig, cli Doer) ([]string, error) {
+	port := 443
+	if strings.HasPrefix(base, "http://") {
+		port = 80
+	}
+	keyEp, err := ParseEndpoint(base+artifactoryDockerAPI+key, port)
+	if err != nil {
+		return nil, err
+	}
+	repos, err := listCatalog(keyEp, auth, cli)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", key, err)
+	}
+	return uniqueSorted(repos), nil
+}
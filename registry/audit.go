@@ -0,0 +1,426 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+
+	"dockdiver/client"
+)
+
+// Severity ranks an audit finding
+type Severity string
+
+const (
+	SeverityCritical Severity = "critical"
+	SeverityHigh     Severity = "high"
+	SeverityMedium   Severity = "medium"
+	SeverityLow      Severity = "low"
+)
+
+// severityRank orders findings from the most to the least severe
+var severityRank = map[Severity]int{
+	SeverityCritical: 0,
+	SeverityHigh:     1,
+	SeverityMedium:   2,
+	SeverityLow:      3,
+}
+
+// Finding is a misconfiguration reported by AuditRegistry
+type Finding struct {
+	ID       string   `json:"id"`
+	Severity Severity `json:"severity"`
+	Title    string   `json:"title"`
+	Detail   string   `json:"detail"`
+	URL      string   `json:"url,omitempty"`
+}
+
+// debugPort is where CNCF Distribution serves its debug endpoints by default
+const debugPort = 5001
+
+// auditor runs the checks of AuditRegistry and collects their findings
+type auditor struct {
+	ep       Endpoint
+	auth     client.AuthConfig
+	cli      *client.Client
+	findings []Finding
+	warning  func(...interface{}) string
+}
+
+func (a *auditor) report(f Finding) {
+	a.findings = append(a.findings, f)
+}
+
+// credentials reports whether the user supplied any credentials, which
+// makes write access expected rather than a misconfiguration
+func (a *auditor) credentials() bool {
+	return a.auth.Username != "" || a.auth.Password != "" || a.auth.Bearer != "" || a.auth.Headers != ""
+}
+
+// AuditRegistry checks a registry for common misconfigurations: plaintext
+// transport and authentication, anonymous catalog and pull access, an
+// enabled DELETE API, blob uploads and exposed debug endpoints. The upload
+// check starts an upload session and cancels it at once; nothing is
+// deleted, as DELETE is only tried on a digest that cannot exist. known lists
+// repositories found by other means, such as -brute-repos, which are checked
+// for anonymous pulls when the catalog is denied.
+func AuditRegistry(ep Endpoint, auth client.AuthConfig, known []string, cli *client.Client) ([]Finding, error) {
+	a := &auditor{ep: ep, auth: auth, cli: cli, warning: color.New(color.FgYellow).SprintFunc()}
+
+	resp, _, err := cli.Probe("GET", ep.V2(""), client.AuthConfig{}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query /v2/: %w", err)
+	}
+	a.checkTransport(resp)
+
+	repos := a.checkAnonymousCatalog()
+	repo := a.checkAnonymousPull(repos)
+	if repo == "" {
+		// The catalog is often denied while public pulls are allowed, so the
+		// repositories visible with credentials or known otherwise are tried
+		var candidates []string
+		for _, r := range append(a.visibleRepositories(), known...) {
+			if !slices.Contains(repos, r) && !slices.Contains(candidates, r) {
+				candidates = append(candidates, r)
+			}
+		}
+		repo = a.checkAnonymousPull(candidates)
+		switch {
+		case repo != "":
+		case len(repos) > 0:
+			repo = repos[0]
+		case len(candidates) > 0:
+			repo = candidates[0]
+		}
+	}
+	a.checkDelete(repo)
+	a.checkUpload(repo)
+	a.checkDebugEndpoints()
+
+	sort.SliceStable(a.findings, func(i, j int) bool {
+		return severityRank[a.findings[i].Severity] < severityRank[a.findings[j].Severity]
+	})
+	return a.findings, nil
+}
+
+// checkTransport flags plain HTTP and credentials or tokens exchanged over it
+func (a *auditor) checkTransport(resp *http.Response) {
+	if a.ep.Scheme == "http" {
+		a.report(Finding{
+			ID:       "plaintext-http",
+			Severity: SeverityMedium,
+			Title:    "Registry served over HTTP without TLS",
+			Detail:   "Image contents, manifests and any credentials can be read and modified in transit",
+			URL:      a.ep.V2(""),
+		})
+	}
+
+	for _, header := range resp.Header.Values("Www-Authenticate") {
+		challenge, ok := client.ParseChallenge(header)
+		if !ok {
+			continue
+		}
+		switch {
+		case challenge.Scheme == "basic" && a.ep.Scheme == "http":
+			a.report(Finding{
+				ID:       "plaintext-basic-auth",
+				Severity: SeverityHigh,
+				Title:    "Basic authentication over plaintext HTTP",
+				Detail:   "The registry asks for Basic credentials without TLS, so every client sends its password in clear text",
+				URL:      a.ep.V2(""),
+			})
+		case challenge.Scheme == "bearer" && strings.HasPrefix(strings.ToLower(challenge.Params["realm"]), "http://"):
+			a.report(Finding{
+				ID:       "plaintext-token-realm",
+				Severity: SeverityHigh,
+				Title:    "Token service over plaintext HTTP",
+				Detail:   "Clients send their credentials to the token realm and receive tokens without TLS",
+				URL:      challenge.Params["realm"],
+			})
+		case challenge.Scheme == "bearer" && a.ep.Scheme == "http":
+			a.report(Finding{
+				ID:       "plaintext-bearer",
+				Severity: SeverityMedium,
+				Title:    "Bearer tokens sent over plaintext HTTP",
+				Detail:   "Tokens issued by the realm are replayed to the registry without TLS and can be captured",
+				URL:      a.ep.V2(""),
+			})
+		}
+	}
+}
+
+// anonymousGet requests url without credentials. A Bearer challenge is
+// answered with an anonymous token, as registries that allow anonymous
+// pulls usually do it that way.
+func (a *auditor) anonymousGet(url string) (*http.Response, error) {
+	resp, err := a.cli.Do("GET", url, client.AuthConfig{}, nil)
+	if !errors.Is(err, client.ErrUnauthorized) {
+		return resp, err
+	}
+	tokenAuth, authErr := a.cli.Reauthenticate(err, client.AuthConfig{})
+	if authErr != nil {
+		return nil, err
+	}
+	return a.cli.Do("GET", url, tokenAuth, nil)
+}
+
+// checkAnonymousCatalog lists the catalog without credentials and returns
+// the repositories it lists
+func (a *auditor) checkAnonymousCatalog() []string {
+	catalogURL := a.ep.V2("_catalog?n=100")
+	resp, err := a.anonymousGet(catalogURL)
+	if err != nil {
+		return nil
+	}
+	var catalog struct {
+		Repositories []string `json:"repositories"`
+	}
+	decodeErr := json.NewDecoder(resp.Body).Decode(&catalog)
+	resp.Body.Close()
+	if decodeErr != nil {
+		return nil
+	}
+	a.report(Finding{
+		ID:       "anonymous-catalog",
+		Severity: SeverityHigh,
+		Title:    "Anonymous catalog access",
+		Detail:   fmt.Sprintf("The catalog lists %d repositories without credentials", len(catalog.Repositories)),
+		URL:      catalogURL,
+	})
+	return catalog.Repositories
+}
+
+// checkAnonymousPull lists the tags of repos and pulls a manifest without
+// credentials, stopping at the first success. It returns the repository it
+// pulled from, if any.
+func (a *auditor) checkAnonymousPull(repos []string) string {
+	for _, repo := range repos {
+		tagsURL := a.ep.V2("%s/tags/list", repo)
+		resp, err := a.anonymousGet(tagsURL)
+		if err != nil {
+			continue
+		}
+		var tags struct {
+			Tags []string `json:"tags"`
+		}
+		decodeErr := json.NewDecoder(resp.Body).Decode(&tags)
+		resp.Body.Close()
+		if decodeErr != nil || len(tags.Tags) == 0 {
+			continue
+		}
+
+		manifestURL := a.ep.V2("%s/manifests/%s", repo, tags.Tags[0])
+		resp, err = a.anonymousGet(manifestURL)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		a.report(Finding{
+			ID:       "anonymous-pull",
+			Severity: SeverityHigh,
+			Title:    "Anonymous pull",
+			Detail:   fmt.Sprintf("%s:%s can be pulled without credentials", repo, tags.Tags[0]),
+			URL:      manifestURL,
+		})
+		return repo
+	}
+	return ""
+}
+
+// visibleRepositories returns the repositories the catalog lists with the
+// supplied credentials, so the write checks target a repository that exists
+func (a *auditor) visibleRepositories() []string {
+	if !a.credentials() {
+		return nil // Already listed anonymously
+	}
+	repos, err := listCatalog(a.ep, a.auth, a.cli)
+	if err != nil {
+		return nil
+	}
+	return repos
+}
+
+// auditRepo returns repo, or a name that cannot exist when no repository is
+// known, so write checks still reach the registry's handlers
+func auditRepo(repo string) string {
+	if repo == "" {
+		return randomRepositoryName()
+	}
+	return repo
+}
+
+// checkDelete sends DELETE for a manifest digest that cannot exist. A
+// registry with deletion disabled answers 405 UNSUPPORTED before looking the
+// manifest up, and one that denies it answers 401 or 403.
+func (a *auditor) checkDelete(repo string) {
+	url := a.ep.V2("%s/manifests/sha256:%s", auditRepo(repo), strings.Repeat("0", 64))
+	resp, body, _, err := probeReauth("DELETE", url, a.auth, a.cli)
+	if err != nil {
+		fmt.Printf("%s DELETE check failed: %v\n", a.warning("[!]"), err)
+		return
+	}
+	var parsed struct {
+		Errors []client.ErrorDetail `json:"errors"`
+	}
+	var code client.ErrorCode
+	if json.Unmarshal(body, &parsed) == nil && len(parsed.Errors) > 0 {
+		code = parsed.Errors[0].Code
+	}
+	// Only a 202, or a lookup error in the distribution format, shows that the
+	// request got past the check for deletion being enabled
+	switch {
+	case resp.StatusCode == http.StatusAccepted:
+	case resp.StatusCode == http.StatusNotFound && (code == client.CodeManifestUnknown || code == client.CodeNameUnknown):
+	default:
+		return
+	}
+
+	severity := SeverityMedium
+	who := "with the supplied credentials"
+	if !a.credentials() {
+		severity, who = SeverityCritical, "without credentials"
+	}
+	a.report(Finding{
+		ID:       "delete-enabled",
+		Severity: severity,
+		Title:    "DELETE API enabled",
+		Detail:   fmt.Sprintf("Manifests can be deleted %s (probe answered %s)", who, resp.Status),
+		URL:      url,
+	})
+}
+
+// checkUpload starts a blob upload and cancels the session right away
+func (a *auditor) checkUpload(repo string) {
+	repo = auditRepo(repo)
+	url := a.ep.V2("%s/blobs/uploads/", repo)
+	started, cancelled, err := startUpload(a.ep, repo, a.auth, a.cli)
+	if err != nil {
+		fmt.Printf("%s Upload check failed: %v\n", a.warning("[!]"), err)
+		return
+	}
+	if !started {
+		return
+	}
+	session := "the session was cancelled"
+	if !cancelled {
+		session = "the session could not be cancelled and stays open until the registry purges it"
+	}
+
+	severity := SeverityLow
+	who := "with the supplied credentials"
+	if !a.credentials() {
+		severity, who = SeverityCritical, "without credentials"
+	}
+	a.report(Finding{
+		ID:       "blob-upload",
+		Severity: severity,
+		Title:    "Blob upload allowed",
+		Detail:   fmt.Sprintf("An upload session could be started %s, so images can likely be pushed or overwritten; %s", who, session),
+		URL:      url,
+	})
+}
+
+// debugEndpoints are the debug and metrics paths checked on the registry
+// port and on the default debug port
+var debugEndpoints = []struct {
+	path     string
+	marker   string
+	severity Severity
+	title    string
+}{
+	{"/debug/pprof/", "profile", SeverityHigh, "Go profiler exposed"},
+	{"/debug/vars", "memstats", SeverityMedium, "expvar debug variables exposed"},
+	{"/metrics", "# HELP", SeverityLow, "Prometheus metrics exposed"},
+}
+
+// checkDebugEndpoints looks for debug and metrics endpoints without
+// credentials, on the registry port and the default debug port
+func (a *auditor) checkDebugEndpoints() {
+	origins := []string{a.ep.Origin()}
+	if a.ep.Port != debugPort {
+		debug := a.ep
+		debug.Port = debugPort
+		origins = append(origins, debug.WithScheme("http").Origin())
+	}
+
+	for _, origin := range origins {
+		for _, d := range debugEndpoints {
+			url := origin + d.path
+			resp, body, err := a.cli.Probe("GET", url, client.AuthConfig{}, nil)
+			if err != nil {
+				if origin != a.ep.Origin() {
+					break // Nothing listens on the debug port
+				}
+				continue
+			}
+			if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), d.marker) {
+				continue
+			}
+			a.report(Finding{
+				ID:       "debug-endpoint",
+				Severity: d.severity,
+				Title:    d.title,
+				Detail:   fmt.Sprintf("%s answers without credentials", d.path),
+				URL:      url,
+			})
+		}
+	}
+}
+
+// startUpload starts a blob upload to repo and cancels it at once. It
+// reports whether the registry accepted the upload and whether the session
+// was cancelled. A Bearer challenge is answered first, as push scopes are
+// usually granted per request.
+func startUpload(ep Endpoint, repo string, auth client.AuthConfig, cli *client.Client) (started, cancelled bool, err error) {
+	resp, _, auth, err := probeReauth("POST", ep.V2("%s/blobs/uploads/", repo), auth, cli)
+	if err != nil {
+		return false, false, err
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		return false, false, nil
+	}
+
+	warning := color.New(color.FgYellow).SprintFunc()
+	location := resp.Header.Get("Location")
+	if location == "" {
+		fmt.Printf("%s Upload session to %s has no Location and cannot be cancelled\n", warning("[!]"), repo)
+		return true, false, nil
+	}
+	uploadURL, err := ep.Resolve(location)
+	if err != nil {
+		fmt.Printf("%s Failed to cancel upload session %s: %v\n", warning("[!]"), location, err)
+		return true, false, nil
+	}
+	resp, _, err = cli.Probe("DELETE", uploadURL, auth, nil)
+	if err == nil && resp.StatusCode/100 != 2 {
+		err = fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	if err != nil {
+		fmt.Printf("%s Failed to cancel upload session %s: %v\n", warning("[!]"), uploadURL, err)
+		return true, false, nil
+	}
+	return true, true, nil
+}
+
+// probeReauth sends a single probe, answering a Bearer challenge with a
+// token for the scope it names and probing once more. It returns the
+// credentials of the last attempt.
+func probeReauth(method, url string, auth client.AuthConfig, cli *client.Client) (*http.Response, []byte, client.AuthConfig, error) {
+	resp, body, err := cli.Probe(method, url, auth, nil)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, body, auth, err
+	}
+	challenge := &client.RegistryError{URL: url, StatusCode: resp.StatusCode, Status: resp.Status, Challenge: resp.Header.Get("Www-Authenticate")}
+	tokenAuth, authErr := cli.Reauthenticate(challenge, auth)
+	if authErr != nil {
+		return resp, body, auth, nil
+	}
+	resp, body, err = cli.Probe(method, url, tokenAuth, nil)
+	return resp, body, tokenAuth, err
+}
@@ -0,0 +1,358 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+
+	"dockdiver/client"
+)
+
+// RepoStatus is what probing a candidate repository name revealed
+type RepoStatus string
+
+const (
+	RepoFound  RepoStatus = "found"  // Tags could be listed
+	RepoDenied RepoStatus = "denied" // DENIED: the name may exist but the credentials cannot pull it
+)
+
+// RepoHit is a candidate repository name that did not come back NAME_UNKNOWN
+type RepoHit struct {
+	Name   string     `json:"name"`
+	Status RepoStatus `json:"status"`
+	Tags   []string   `json:"tags,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// RepositoryCandidates expands words into repository names: each word on
+// its own and under every namespace. Names are lowercased, as repository
+// names cannot contain upper case letters, and duplicates are dropped.
+func RepositoryCandidates(words, namespaces []string) []string {
+	var candidates []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		name = strings.ToLower(strings.Trim(name, "/"))
+		if name != "" && !seen[name] {
+			seen[name] = true
+			candidates = append(candidates, name)
+		}
+	}
+	for _, word := range words {
+		add(word)
+		for _, ns := range namespaces {
+			add(strings.Trim(ns, "/") + "/" + strings.Trim(word, "/"))
+		}
+	}
+	return candidates
+}
+
+// BruteRepositories probes /v2/<name>/tags/list for every candidate name,
+// for registries whose _catalog is disabled but still serve pulls by name.
+// NAME_UNKNOWN and plain 404 answers mean the name does not exist; DENIED
+// is reported separately, as it usually means the name exists but is not
+// accessible. Bearer challenges are answered per name with a scoped token.
+// concurrency names are probed in parallel, still within the rate limit.
+func BruteRepositories(ep Endpoint, candidates []string, auth client.AuthConfig, cli *client.Client, concurrency int) ([]RepoHit, error) {
+	success := color.New(color.FgGreen).SprintFunc()
+	errorColor := color.New(color.FgRed).SprintFunc()
+	warning := color.New(color.FgYellow).SprintFunc()
+
+	fmt.Printf("%s Probing %d candidate repository names\n", warning("[!]"), len(candidates))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var hits []RepoHit
+	unauthorized := 0
+	semaphore := make(chan struct{}, workers(concurrency))
+
+	for _, name := range candidates {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(name string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			tags, err := probeRepository(ep, name, auth, cli)
+			var hit *RepoHit
+			switch {
+			case err == nil:
+				hit = &RepoHit{Name: name, Status: RepoFound, Tags: tags}
+				fmt.Printf("%s Found repository %s (%d tags)\n", success("[+]"), name, len(tags))
+			case errors.Is(err, client.ErrDenied):
+				hit = &RepoHit{Name: name, Status: RepoDenied, Error: err.Error()}
+				fmt.Printf("%s Access denied to %s, the repository may exist: %v\n", warning("[!]"), name, err)
+			case errors.Is(err, client.ErrNameUnknown), isNotFound(err):
+			case errors.Is(err, client.ErrUnauthorized):
+				mu.Lock()
+				unauthorized++
+				mu.Unlock()
+			default:
+				fmt.Printf("%s Error probing %s: %v\n", errorColor("[-]"), name, err)
+			}
+			if hit != nil {
+				mu.Lock()
+				hits = append(hits, *hit)
+				mu.Unlock()
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Name < hits[j].Name })
+	if unauthorized > 0 {
+		fmt.Printf("%s %d names could not be tested without valid credentials\n", warning("[!]"), unauthorized)
+		if unauthorized == len(candidates) {
+			return nil, fmt.Errorf("every probe was unauthorized: %w", client.ErrUnauthorized)
+		}
+	}
+	return hits, nil
+}
+
+// probeRepository lists the tags of name, answering a Bearer challenge with
+// a token scoped to it when the first attempt is unauthorized
+func probeRepository(ep Endpoint, name string, auth client.AuthConfig, cli *client.Client) ([]string, error) {
+	tags, err := listTags(ep, name, auth, cli)
+	if !errors.Is(err, client.ErrUnauthorized) {
+		return tags, err
+	}
+	tokenAuth, authErr := cli.Reauthenticate(err, auth)
+	if authErr != nil {
+		return nil, err
+	}
+	return listTags(ep, name, tokenAuth, cli)
+}
+
+// listTags returns the tags of a repository, which may be none
+func listTags(ep Endpoint, repo string, auth client.AuthConfig, cli *client.Client) ([]string, error) {
+	resp, err := cli.MakeRequest(ep.V2("%s/tags/list", repo), auth)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tags struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("failed to decode tags: %v", err)
+	}
+	return tags.Tags, nil
+}
+
+// isNotFound reports a 404 without a distribution error code, as sent by
+// registries and reverse proxies that do not use the error format
+func isNotFound(err error) bool {
+	var regErr *client.RegistryError
+	return errors.As(err, &regErr) && regErr.StatusCode == http.StatusNotFound
+}
+
+// CommonTags are the tags tried by -brute-tags besides those of a wordlist
+var CommonTags = []string{
+	"latest", "dev", "develop", "development", "prod", "production", "staging", "stage",
+	"test", "testing", "qa", "uat", "main", "master", "stable", "edge", "nightly",
+	"release", "beta", "alpha", "rc", "canary", "debug", "backup", "old", "local",
+	"v1", "v2", "v3", "1", "2", "3", "1.0", "2.0", "3.0", "1.0.0", "2.0.0", "3.0.0",
+}
+
+// maxTagExpansion bounds how many tags a single wordlist pattern may produce
+const maxTagExpansion = 100000
+
+// ExpandTagPatterns expands wordlist entries into tags. An entry may hold
+// numeric ranges and alternatives, e.g. v1.{0..9}.{0..20}, {dev,prod}-{01..12}
+// or 2024{01..12}{01..31}; a range keeps the zero padding of its start.
+func ExpandTagPatterns(patterns []string) ([]string, error) {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		expanded, err := expandPattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag pattern %q: %v", pattern, err)
+		}
+		for _, tag := range expanded {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags, nil
+}
+
+// expandPattern expands the first {...} group of pattern and recurses on
+// the rest
+func expandPattern(pattern string) ([]string, error) {
+	start := strings.Index(pattern, "{")
+	if start < 0 {
+		return []string{pattern}, nil
+	}
+	end := strings.Index(pattern[start:], "}")
+	if end < 0 {
+		return nil, fmt.Errorf("unclosed {")
+	}
+	end += start
+	prefix, group := pattern[:start], pattern[start+1:end]
+
+	var options []string
+	if from, to, ok := strings.Cut(group, ".."); ok {
+		lo, err1 := strconv.Atoi(from)
+		hi, err2 := strconv.Atoi(to)
+		if err1 != nil || err2 != nil || lo > hi {
+			return nil, fmt.Errorf("invalid range {%s}", group)
+		}
+		if hi-lo >= maxTagExpansion {
+			return nil, fmt.Errorf("range {%s} is too large", group)
+		}
+		width := 0
+		if len(from) > 1 && from[0] == '0' {
+			width = len(from)
+		}
+		for i := lo; i <= hi; i++ {
+			options = append(options, fmt.Sprintf("%0*d", width, i))
+		}
+	} else {
+		options = strings.Split(group, ",")
+	}
+
+	rest, err := expandPattern(pattern[end+1:])
+	if err != nil {
+		return nil, err
+	}
+	if len(options)*len(rest) > maxTagExpansion {
+		return nil, fmt.Errorf("expands to more than %d tags", maxTagExpansion)
+	}
+	var tags []string
+	for _, option := range options {
+		for _, suffix := range rest {
+			tags = append(tags, prefix+option+suffix)
+		}
+	}
+	return tags, nil
+}
+
+// BruteTags probes /v2/<repo>/manifests/<tag> with HEAD for every candidate
+// and returns the tags that exist, in candidate order. The first candidate
+// is probed alone so a Bearer challenge is answered once and its token is
+// reused for the others.
+func BruteTags(ep Endpoint, repo string, candidates []string, auth client.AuthConfig, cli *client.Client, concurrency int) ([]string, error) {
+	success := color.New(color.FgGreen).SprintFunc()
+	errorColor := color.New(color.FgRed).SprintFunc()
+	warning := color.New(color.FgYellow).SprintFunc()
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no tag candidates")
+	}
+	fmt.Printf("%s Probing %d candidate tags for %s\n", warning("[!]"), len(candidates), repo)
+
+	found := make([]bool, len(candidates))
+	report := func(i int, digest string, err error) error {
+		switch {
+		case err == nil:
+			found[i] = true
+			fmt.Printf("%s Found tag %s:%s %s\n", success("[+]"), repo, candidates[i], digest)
+		case errors.Is(err, client.ErrManifestUnknown), isNotFound(err):
+		default:
+			return err
+		}
+		return nil
+	}
+
+	digest, err := headManifest(ep, repo, candidates[0], auth, cli)
+	if errors.Is(err, client.ErrUnauthorized) {
+		if tokenAuth, authErr := cli.Reauthenticate(err, auth); authErr == nil {
+			auth = tokenAuth
+			digest, err = headManifest(ep, repo, candidates[0], auth, cli)
+		}
+	}
+	if err := report(0, digest, err); err != nil {
+		return nil, fmt.Errorf("failed to probe %s:%s: %w", repo, candidates[0], err)
+	}
+
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, workers(concurrency))
+	for i := 1; i < len(candidates); i++ {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			digest, err := headManifest(ep, repo, candidates[i], auth, cli)
+			if err := report(i, digest, err); err != nil {
+				fmt.Printf("%s Error probing %s:%s: %v\n", errorColor("[-]"), repo, candidates[i], err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var tags []string
+	for i, ok := range found {
+		if ok {
+			tags = append(tags, candidates[i])
+		}
+	}
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("%w for %s among %d candidates", errNoTags, repo, len(candidates))
+	}
+	return tags, nil
+}
+
+// manifestAccept lists the manifest types a tag may point to, so tags of
+// multi-platform images are found too
+var manifestAccept = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+// headManifest checks that repo:tag exists and returns its digest. Registries
+// that do not allow HEAD are asked with GET instead.
+func headManifest(ep Endpoint, repo, tag string, auth client.AuthConfig, cli *client.Client) (string, error) {
+	header := http.Header{"Accept": {strings.Join(manifestAccept, ", ")}}
+	url := ep.V2("%s/manifests/%s", repo, tag)
+	resp, err := cli.Do("HEAD", url, auth, header)
+	if errors.Is(err, client.ErrUnsupported) {
+		resp, err = cli.Do("GET", url, auth, header)
+	}
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("Docker-Content-Digest"), nil
+}
+
+// repositoryTags returns the tags of repo for dumping. When tags/list is
+// forbidden or empty and tag candidates are set, the tags are found by
+// probing the candidates instead.
+func repositoryTags(ep Endpoint, repo string, auth client.AuthConfig, cli *client.Client, opts DumpOptions) ([]string, error) {
+	tags, err := fetchTags(ep, repo, auth, cli)
+	if err == nil || len(opts.TagCandidates) == 0 || !tagsForbidden(err, auth) {
+		return tags, err
+	}
+	fmt.Printf("%s Listing tags of %s failed (%v), probing candidate tags\n", color.New(color.FgYellow).SprintFunc()("[!]"), repo, err)
+	return BruteTags(ep, repo, opts.TagCandidates, auth, cli, opts.Concurrency)
+}
+
+// tagsForbidden reports whether a tags/list error is worth working around
+// by probing manifests. An unauthorized error with a Bearer challenge is
+// left to re-authentication first, unless a token is already being sent.
+func tagsForbidden(err error, auth client.AuthConfig) bool {
+	switch {
+	case errors.Is(err, errNoTags), errors.Is(err, client.ErrDenied), errors.Is(err, client.ErrUnsupported):
+		return true
+	case errors.Is(err, client.ErrUnauthorized):
+		var regErr *client.RegistryError
+		if auth.Bearer != "" || !errors.As(err, &regErr) {
+			return true
+		}
+		challenge, ok := client.ParseChallenge(regErr.Challenge)
+		return !ok || challenge.Scheme != "bearer"
+	}
+	return false
+}
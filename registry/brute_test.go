@@ -0,0 +1,68 @@
+package registry
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandTagPatterns(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "plain tags",
+			patterns: []string{"latest", "prod"},
+			want:     []string{"latest", "prod"},
+		},
+		{
+			name:     "range",
+			patterns: []string{"v1.{8..11}"},
+			want:     []string{"v1.8", "v1.9", "v1.10", "v1.11"},
+		},
+		{
+			name:     "zero padded range",
+			patterns: []string{"2024{09..11}"},
+			want:     []string{"202409", "202410", "202411"},
+		},
+		{
+			name:     "alternatives and ranges",
+			patterns: []string{"{dev,prod}-{1..2}"},
+			want:     []string{"dev-1", "dev-2", "prod-1", "prod-2"},
+		},
+		{
+			name:     "duplicates are dropped",
+			patterns: []string{"v{1..2}", "v2", "latest", "latest"},
+			want:     []string{"v1", "v2", "latest"},
+		},
+		{
+			name:     "single value range",
+			patterns: []string{"{3..3}"},
+			want:     []string{"3"},
+		},
+		{name: "unclosed group", patterns: []string{"v{1..2"}, wantErr: true},
+		{name: "reversed range", patterns: []string{"{9..1}"}, wantErr: true},
+		{name: "non-numeric range", patterns: []string{"{a..z}"}, wantErr: true},
+		{name: "range too large", patterns: []string{"{0..100000}"}, wantErr: true},
+		{name: "product too large", patterns: []string{"{0..999}{0..999}"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExpandTagPatterns(tt.patterns)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ExpandTagPatterns(%q) = %v, want an error", tt.patterns, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExpandTagPatterns(%q): %v", tt.patterns, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExpandTagPatterns(%q) = %v, want %v", tt.patterns, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+
+	"dockdiver/client"
+)
+
+// Doer sends registry and product API requests. *client.Client implements
+// it; catalog sources only depend on this, so they can be run against
+// recorded responses.
+type Doer interface {
+	Do(method, url string, auth client.AuthConfig, header http.Header) (*http.Response, error)
+}
+
+// CatalogSource lists the repositories of a registry through one API
+type CatalogSource interface {
+	Name() string
+	Repositories(ep Endpoint, auth client.AuthConfig, cli Doer) ([]string, error)
+}
+
+// CatalogOptions configures the catalog sources
+type CatalogOptions struct {
+	// APIBase is the root of the product API when it differs from the
+	// registry origin, e.g. https://gitlab.example.com for registry.example.com
+	APIBase string
+}
+
+// apiBase returns the origin product API requests are sent to
+func (o CatalogOptions) apiBase(ep Endpoint) string {
+	if o.APIBase != "" {
+		return strings.TrimRight(o.APIBase, "/")
+	}
+	return ep.Origin()
+}
+
+// catalogSources builds the sources selectable with CatalogSources
+var catalogSources = map[string]func(CatalogOptions) CatalogSource{
+	"distribution": func(CatalogOptions) CatalogSource { return distributionCatalog{} },
+	"harbor":       func(o CatalogOptions) CatalogSource { return harborCatalog{o} },
+	"gitlab":       func(o CatalogOptions) CatalogSource { return gitlabCatalog{o} },
+	"nexus":        func(o CatalogOptions) CatalogSource { return nexusCatalog{o} },
+	"artifactory":  func(o CatalogOptions) CatalogSource { return artifactoryCatalog{o} },
+}
+
+// productCatalogs maps fingerprinted products to their catalog source
+var productCatalogs = map[string]string{
+	ProductHarbor:      "harbor",
+	ProductGitLab:      "gitlab",
+	ProductNexus:       "nexus",
+	ProductArtifactory: "artifactory",
+}
+
+// CatalogSourceNames returns the names accepted by CatalogSources
+func CatalogSourceNames() []string {
+	var names []string
+	for name := range catalogSources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CatalogSourceFor returns the catalog source matching a product found by
+// FingerprintRegistry, or "" if it has none besides _catalog
+func CatalogSourceFor(product string) string {
+	return productCatalogs[product]
+}
+
+// CatalogSources builds the named sources, to be tried in order by
+// ListRepositories
+func CatalogSources(names []string, opts CatalogOptions) ([]CatalogSource, error) {
+	var sources []CatalogSource
+	for _, name := range names {
+		build, ok := catalogSources[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown catalog source %q, expected one of %s", name, strings.Join(CatalogSourceNames(), ", "))
+		}
+		sources = append(sources, build(opts))
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no catalog source given")
+	}
+	return sources, nil
+}
+
+// ListRepositories lists the repositories of a registry with sources, tried
+// in order, returning the first non-empty result; without sources only
+// _catalog is used. An empty result from the last source is returned as is,
+// so an empty registry is not an error. When every source fails, the error of
+// the first one is returned.
+func ListRepositories(ep Endpoint, auth client.AuthConfig, cli Doer, sources []CatalogSource) ([]string, error) {
+	success := color.New(color.FgGreen).SprintFunc()
+	warning := color.New(color.FgYellow).SprintFunc()
+
+	if len(sources) == 0 {
+		sources = []CatalogSource{distributionCatalog{}}
+	}
+	var firstErr error
+	for i, source := range sources {
+		repos, err := source.Repositories(ep, auth, cli)
+		if err == nil && len(repos) == 0 && i+1 < len(sources) {
+			err = fmt.Errorf("no repositories found")
+		}
+		if err == nil {
+			if i > 0 {
+				fmt.Printf("%s Listed %d repositories through the %s API\n", success("[+]"), len(repos), source.Name())
+			}
+			return repos, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if i+1 < len(sources) {
+			fmt.Printf("%s Listing through %s failed: %v, trying %s\n", warning("[!]"), source.Name(), err, sources[i+1].Name())
+		}
+	}
+	return nil, firstErr
+}
+
+// distributionCatalog lists repositories with /v2/_catalog
+type distributionCatalog struct{}
+
+func (distributionCatalog) Name() string { return "distribution" }
+
+func (distributionCatalog) Repositories(ep Endpoint, auth client.AuthConfig, cli Doer) ([]string, error) {
+	return listCatalog(ep, auth, cli)
+}
+
+// uniqueSorted returns names sorted without duplicates
+func uniqueSorted(names []string) []string {
+	sort.Strings(names)
+	var unique []string
+	for i, name := range names {
+		if i == 0 || name != names[i-1] {
+			unique = append(unique, name)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,320 @@
+package registry
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"dockdiver/client"
+)
+
+// fixture is a recorded response, its body read from testdata/catalog
+type fixture struct {
+	status int
+	file   string
+	header http.Header
+}
+
+// fixtureDoer answers requests from fixtures keyed by URL and, like
+// client.Client, turns non-2xx responses into a *client.RegistryError.
+// Requests without a fixture get a 404.
+type fixtureDoer struct {
+	t        *testing.T
+	fixtures map[string]fixture
+	requests []string
+	auths    []client.AuthConfig
+}
+
+func (d *fixtureDoer) Do(method, url string, auth client.AuthConfig, header http.Header) (*http.Response, error) {
+	d.requests = append(d.requests, method+" "+url)
+	d.auths = append(d.auths, auth)
+	f, ok := d.fixtures[url]
+	if !ok {
+		f = fixture{status: http.StatusNotFound}
+	}
+	if f.status == 0 {
+		f.status = http.StatusOK
+	}
+	if f.status < 200 || f.status > 299 {
+		return nil, &client.RegistryError{URL: url, StatusCode: f.status, Status: http.StatusText(f.status)}
+	}
+	body := []byte("{}")
+	if f.file != "" {
+		var err error
+		body, err = os.ReadFile(filepath.Join("testdata", "catalog", f.file))
+		if err != nil {
+			d.t.Fatalf("reading fixture: %v", err)
+		}
+	}
+	h := http.Header{}
+	for k, v := range f.header {
+		h[k] = v
+	}
+	return &http.Response{StatusCode: f.status, Header: h, Body: io.NopCloser(strings.NewReader(string(body)))}, nil
+}
+
+func mustEndpoint(t *testing.T, raw string) Endpoint {
+	t.Helper()
+	ep, err := ParseEndpoint(raw, 443)
+	if err != nil {
+		t.Fatalf("ParseEndpoint(%q): %v", raw, err)
+	}
+	return ep
+}
+
+func TestGitLabCatalog(t *testing.T) {
+	const api = "https://gitlab.example.com/api/v4"
+	doer := &fixtureDoer{t: t, fixtures: map[string]fixture{
+		api + "/projects?simple=true&membership=true&per_page=100&page=1": {file: "gitlab/projects_page1.json", header: http.Header{"X-Next-Page": {"2"}}},
+		api + "/projects?simple=true&membership=true&per_page=100&page=2": {file: "gitlab/projects_page2.json", header: http.Header{"X-Next-Page": {""}}},
+		api + "/projects/11/registry/repositories?per_page=100&page=1":    {file: "gitlab/repositories_11.json"},
+		api + "/projects/12/registry/repositories?per_page=100&page=1":    {status: http.StatusForbidden, file: "gitlab/forbidden.json"},
+		api + "/projects/13/registry/repositories?per_page=100&page=1":    {file: "gitlab/repositories_13.json"},
+	}}
+	source := gitlabCatalog{CatalogOptions{APIBase: "https://gitlab.example.com/"}}
+	ep := mustEndpoint(t, "https://registry.example.com")
+
+	repos, err := source.Repositories(ep, client.AuthConfig{Username: "u", Password: "glpat-secret"}, doer)
+	if err != nil {
+		t.Fatalf("Repositories: %v", err)
+	}
+	want := []string{"acme/api", "acme/api/worker", "acme/web"}
+	if !reflect.DeepEqual(repos, want) {
+		t.Errorf("Repositories = %v, want %v", repos, want)
+	}
+	for _, auth := range doer.auths {
+		if auth.Username != "" || !strings.Contains(auth.Headers, `"PRIVATE-TOKEN":"glpat-secret"`) {
+			t.Errorf("request sent with %+v, want the password as PRIVATE-TOKEN", auth)
+		}
+	}
+}
+
+func TestGitLabCatalogErrors(t *testing.T) {
+	doer := &fixtureDoer{t: t, fixtures: map[string]fixture{
+		"https://gitlab.example.com:443/api/v4/projects?simple=true&per_page=100&page=1": {status: http.StatusUnauthorized, file: "gitlab/unauthorized.json"},
+	}}
+	_, err := gitlabCatalog{}.Repositories(mustEndpoint(t, "https://gitlab.example.com"), client.AuthConfig{}, doer)
+	if !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("Repositories error = %v, want ErrUnauthorized", err)
+	}
+	if len(doer.requests) != 1 || strings.Contains(doer.requests[0], "membership") {
+		t.Errorf("anonymous requests = %v, want one without membership", doer.requests)
+	}
+}
+
+func TestNexusCatalog(t *testing.T) {
+	const rest = "https://nexus.example.com:8083/service/rest/v1"
+	components := map[string]fixture{
+		rest + "/components?repository=docker-hosted":                                          {file: "nexus/components_hosted_page1.json"},
+		rest + "/components?repository=docker-hosted&continuationToken=35303a6235633862633138": {file: "nexus/components_hosted_page2.json"},
+		rest + "/components?repository=docker-proxy":                                           {file: "nexus/components_proxy.json"},
+	}
+	with := func(extra map[string]fixture) map[string]fixture {
+		all := map[string]fixture{}
+		for k, v := range components {
+			all[k] = v
+		}
+		for k, v := range extra {
+			all[k] = v
+		}
+		return all
+	}
+	settings := fixture{file: "nexus/repository_settings.json"}
+	repositories := fixture{file: "nexus/repositories.json"}
+	forbidden := fixture{status: http.StatusForbidden}
+
+	tests := []struct {
+		name     string
+		url      string
+		fixtures map[string]fixture
+		want     []string
+		wantErr  string // Part of the error message
+		wantIs   error
+	}{
+		{
+			name:     "path routing without settings",
+			url:      "https://nexus.example.com:8083/repository/docker-hosted",
+			fixtures: with(map[string]fixture{rest + "/repositorySettings": forbidden, rest + "/repositories": repositories}),
+			want:     []string{"team/app", "team/db"},
+		},
+		{
+			name:     "group connector port",
+			url:      "https://nexus.example.com:8083",
+			fixtures: with(map[string]fixture{rest + "/repositorySettings": settings}),
+			want:     []string{"library/nginx", "team/app", "team/db"},
+		},
+		{
+			name:     "subdomain connector",
+			url:      "https://hub.nexus.example.com:8083",
+			fixtures: with(map[string]fixture{"https://hub.nexus.example.com:8083/service/rest/v1/repositorySettings": settings, "https://hub.nexus.example.com:8083/service/rest/v1/components?repository=docker-proxy": {file: "nexus/components_proxy.json"}}),
+			want:     []string{"library/nginx"},
+		},
+		{
+			name:     "ambiguous connector",
+			url:      "https://nexus.example.com:8083",
+			fixtures: with(map[string]fixture{rest + "/repositorySettings": forbidden, rest + "/repositories": repositories}),
+			wantErr:  "cannot tell which",
+		},
+		{
+			name:     "listing denied",
+			url:      "https://nexus.example.com:8083",
+			fixtures: map[string]fixture{rest + "/repositorySettings": forbidden, rest + "/repositories": forbidden},
+			wantIs:   client.ErrDenied,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doer := &fixtureDoer{t: t, fixtures: tt.fixtures}
+			repos, err := nexusCatalog{}.Repositories(mustEndpoint(t, tt.url), client.AuthConfig{}, doer)
+			switch {
+			case tt.wantIs != nil:
+				if !errors.Is(err, tt.wantIs) {
+					t.Fatalf("Repositories error = %v, want %v", err, tt.wantIs)
+				}
+				return
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Repositories error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Repositories: %v", err)
+			}
+			if !reflect.DeepEqual(repos, tt.want) {
+				t.Errorf("Repositories = %v, want %v", repos, tt.want)
+			}
+		})
+	}
+}
+
+func TestArtifactoryCatalog(t *testing.T) {
+	catalogs := func(origin string) map[string]fixture {
+		api := origin + "/artifactory/api/docker"
+		return map[string]fixture{
+			origin + "/artifactory/api/repositories?packageType=docker": {file: "artifactory/repositories.json"},
+			api + "/docker-local/v2/_catalog?n=100": {file: "artifactory/catalog_local_page1.json", header: http.Header{
+				"Link": {`</artifactory/api/docker/docker-local/v2/_catalog?last=base%2Falpine&n=100>; rel="next"`},
+			}},
+			api + "/docker-local/v2/_catalog?last=base%2Falpine&n=100": {file: "artifactory/catalog_local_page2.json"},
+			api + "/docker-remote/v2/_catalog?n=100":                   {file: "artifactory/catalog_remote.json"},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		url      string
+		fixtures map[string]fixture
+		want     []string
+	}{
+		{
+			name:     "repository path method",
+			url:      "https://acme.jfrog.io",
+			fixtures: catalogs("https://acme.jfrog.io:443"),
+			want:     []string{"docker-local/app", "docker-local/base/alpine", "docker-local/tools/ci", "docker-remote/library/nginx"},
+		},
+		{
+			name:     "subdomain method",
+			url:      "https://docker-remote.acme.jfrog.io",
+			fixtures: catalogs("https://docker-remote.acme.jfrog.io:443"),
+			want:     []string{"library/nginx"},
+		},
+		{
+			name:     "repository API root",
+			url:      "https://acme.jfrog.io/artifactory/api/docker/docker-local",
+			fixtures: catalogs("https://acme.jfrog.io:443"),
+			want:     []string{"app", "base/alpine", "tools/ci"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doer := &fixtureDoer{t: t, fixtures: tt.fixtures}
+			repos, err := artifactoryCatalog{}.Repositories(mustEndpoint(t, tt.url), client.AuthConfig{}, doer)
+			if err != nil {
+				t.Fatalf("Repositories: %v", err)
+			}
+			if !reflect.DeepEqual(repos, tt.want) {
+				t.Errorf("Repositories = %v, want %v", repos, tt.want)
+			}
+		})
+	}
+}
+
+func TestArtifactoryCatalogDenied(t *testing.T) {
+	doer := &fixtureDoer{t: t, fixtures: map[string]fixture{
+		"https://acme.jfrog.io:443/artifactory/api/repositories?packageType=docker": {status: http.StatusForbidden, file: "artifactory/forbidden.json"},
+	}}
+	_, err := artifactoryCatalog{}.Repositories(mustEndpoint(t, "https://acme.jfrog.io"), client.AuthConfig{}, doer)
+	if !errors.Is(err, client.ErrDenied) {
+		t.Errorf("Repositories error = %v, want ErrDenied", err)
+	}
+}
+
+// staticCatalog returns fixed results, for testing ListRepositories
+type staticCatalog struct {
+	name  string
+	repos []string
+	err   error
+}
+
+func (s staticCatalog) Name() string { return s.name }
+
+func (s staticCatalog) Repositories(Endpoint, client.AuthConfig, Doer) ([]string, error) {
+	return s.repos, s.err
+}
+
+func TestListRepositoriesFallback(t *testing.T) {
+	ep := mustEndpoint(t, "https://registry.example.com")
+	first := errors.New("catalog disabled")
+
+	repos, err := ListRepositories(ep, client.AuthConfig{}, nil, []CatalogSource{
+		staticCatalog{name: "a", err: first},
+		staticCatalog{name: "b"},
+		staticCatalog{name: "c", repos: []string{"team/app"}},
+	})
+	if err != nil || !reflect.DeepEqual(repos, []string{"team/app"}) {
+		t.Errorf("ListRepositories = %v, %v, want [team/app]", repos, err)
+	}
+
+	_, err = ListRepositories(ep, client.AuthConfig{}, nil, []CatalogSource{
+		staticCatalog{name: "a", err: first},
+		staticCatalog{name: "b", err: errors.New("second")},
+	})
+	if err != first {
+		t.Errorf("ListRepositories error = %v, want the first source's", err)
+	}
+	// An empty registry is not an error, whether it is listed by the only
+	// source or by the last one
+	repos, err = ListRepositories(ep, client.AuthConfig{}, nil, []CatalogSource{staticCatalog{name: "a"}})
+	if err != nil || len(repos) != 0 {
+		t.Errorf("ListRepositories = %v, %v, want no repositories and no error", repos, err)
+	}
+	repos, err = ListRepositories(ep, client.AuthConfig{}, nil, []CatalogSource{
+		staticCatalog{name: "a"},
+		staticCatalog{name: "b"},
+	})
+	if err != nil || len(repos) != 0 {
+		t.Errorf("ListRepositories = %v, %v, want no repositories and no error", repos, err)
+	}
+}
+
+func TestCatalogSources(t *testing.T) {
+	sources, err := CatalogSources([]string{"Distribution", "nexus"}, CatalogOptions{})
+	if err != nil {
+		t.Fatalf("CatalogSources: %v", err)
+	}
+	if len(sources) != 2 || sources[0].Name() != "distribution" || sources[1].Name() != "nexus" {
+		t.Errorf("CatalogSources = %v", sources)
+	}
+	if _, err := CatalogSources([]string{"quay"}, CatalogOptions{}); err == nil {
+		t.Error("CatalogSources accepted an unknown source")
+	}
+	if _, err := CatalogSources(nil, CatalogOptions{}); err == nil {
+		t.Error("CatalogSources accepted no sources")
+	}
+}
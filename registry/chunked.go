@@ -0,0 +1,148 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"dockdiver/client"
+)
+
+// errRangeUnsupported means the server ignored a Range request
+var errRangeUnsupported = errors.New("server does not support range requests")
+
+// blobSize returns the size of a blob from its descriptor, falling back to a
+// HEAD request when the manifest didn't record it
+func blobSize(url string, blob descriptor, auth client.AuthConfig, cli *client.Client) (int64, error) {
+	if blob.Size > 0 {
+		return blob.Size, nil
+	}
+	resp, err := cli.Do("HEAD", url, auth, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.ContentLength <= 0 {
+		return 0, fmt.Errorf("blob size unknown")
+	}
+	return resp.ContentLength, nil
+}
+
+// getAndStoreBlobChunked downloads a blob as opts.Chunks parallel Range
+// requests written into place, then verifies the SHA-256 of the whole file.
+// Small blobs and servers without range support use a single stream.
+func getAndStoreBlobChunked(url, filename string, blob descriptor, auth client.AuthConfig, cli *client.Client, opts DumpOptions, warning func(...interface{}) string) error {
+	size, err := blobSize(url, blob, auth, cli)
+	if err != nil {
+		fmt.Printf("%s %s: cannot determine blob size (%v), downloading as a single stream\n", warning("[!]"), url, err)
+	}
+	if err != nil || size < opts.ChunkMinSize || opts.Chunks < 2 {
+		_, err := getAndStoreBlob(url, filename, blob.Digest, auth, cli, warning)
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(filename), "blob_*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if err := tmpFile.Truncate(size); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to allocate %d bytes: %v", size, err)
+	}
+
+	chunkSize := (size + int64(opts.Chunks) - 1) / int64(opts.Chunks)
+	var downloaded atomic.Int64
+	var wg sync.WaitGroup
+	errs := make(chan error, opts.Chunks)
+	for start := int64(0); start < size; start += chunkSize {
+		end := min(start+chunkSize, size) - 1
+		wg.Add(1)
+		go func(start, end int64) {
+			defer wg.Done()
+			if err := fetchChunk(url, tmpFile, start, end, auth, cli); err != nil {
+				errs <- err
+				return
+			}
+			done := downloaded.Add(end - start + 1)
+			fmt.Printf("%s Downloading %s: %.2f%% (%d/%d bytes, chunk %d-%d done)\n", warning("[!]"), url, float64(done)/float64(size)*100, done, size, start, end)
+		}(start, end)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if errors.Is(err, errRangeUnsupported) {
+			tmpFile.Close()
+			fmt.Printf("%s %s: %v, downloading as a single stream\n", warning("[!]"), url, err)
+			_, err := getAndStoreBlob(url, filename, blob.Digest, auth, cli, warning)
+			return err
+		}
+		tmpFile.Close()
+		return err
+	}
+
+	// Verify integrity of the reassembled blob
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to rewind temp file: %v", err)
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, tmpFile)
+	tmpFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to hash blob for %s: %v", url, err)
+	}
+	calculatedDigest := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	if calculatedDigest != blob.Digest {
+		return fmt.Errorf("integrity check failed: expected %s, got %s", blob.Digest, calculatedDigest)
+	}
+
+	if err := os.Rename(tmpFile.Name(), filename); err != nil {
+		return fmt.Errorf("failed to move temp file to %s: %v", filename, err)
+	}
+	return nil
+}
+
+// fetchChunk downloads bytes start-end of a blob into the same range of f.
+// Failed requests are already retried by the client, so only a transfer
+// interrupted mid-body is resumed here, from the first missing byte.
+func fetchChunk(url string, f *os.File, start, end int64, auth client.AuthConfig, cli *client.Client) error {
+	const maxAttempts = 3
+	offset := start
+	var lastErr error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		header := http.Header{"Range": []string{fmt.Sprintf("bytes=%d-%d", offset, end)}}
+		resp, err := cli.Do("GET", url, auth, header)
+		if err != nil {
+			return fmt.Errorf("failed to download chunk %d-%d of %s: %v", start, end, url, err)
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			return errRangeUnsupported
+		}
+		if resp.ContentLength >= 0 && resp.ContentLength != end-offset+1 {
+			resp.Body.Close()
+			return fmt.Errorf("chunk %d-%d of %s: server returned %d bytes", offset, end, url, resp.ContentLength)
+		}
+
+		n, err := io.Copy(io.NewOffsetWriter(f, offset), io.LimitReader(resp.Body, end-offset+1))
+		resp.Body.Close()
+		offset += n
+		if offset > end {
+			return nil
+		}
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		lastErr = fmt.Errorf("transfer stopped at byte %d: %v", offset, err)
+	}
+	return fmt.Errorf("failed to download chunk %d-%d of %s: %v", start, end, url, lastErr)
+}
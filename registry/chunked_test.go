@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"dockdiver/client"
+)
+
+func TestFetchChunk(t *testing.T) {
+	blob := strings.Repeat("0123456789", 100)
+	var mu sync.Mutex
+	var ranges []string
+	cut := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil {
+			t.Errorf("bad Range header %q", r.Header.Get("Range"))
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(end-start+1))
+		w.WriteHeader(http.StatusPartialContent)
+		if cut {
+			// Drop the connection halfway through the first transfer
+			cut = false
+			w.Write([]byte(blob[start : start+(end-start+1)/2]))
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.Write([]byte(blob[start : end+1]))
+	}))
+	defer srv.Close()
+
+	cli, err := client.NewClient(1000, client.TLSOptions{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(t.TempDir(), "blob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// An interrupted transfer resumes from the first missing byte
+	if err := fetchChunk(srv.URL+"/blob", f, 100, 299, client.AuthConfig{}, cli); err != nil {
+		t.Fatalf("fetchChunk(): %v", err)
+	}
+	got := make([]byte, 200)
+	if _, err := f.ReadAt(got, 100); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != blob[100:300] {
+		t.Errorf("chunk = %q, want %q", got, blob[100:300])
+	}
+	if want := []string{"bytes=100-299", "bytes=200-299"}; strings.Join(ranges, " ") != strings.Join(want, " ") {
+		t.Errorf("requested ranges %q, want %q", ranges, want)
+	}
+
+	// A failed request is left to the client's retries, not repeated here
+	ranges = nil
+	if err := fetchChunk(srv.URL+"/missing", f, 0, 99, client.AuthConfig{}, cli); err == nil {
+		t.Fatal("fetchChunk() succeeded on a missing blob")
+	}
+	if len(ranges) != 1 {
+		t.Errorf("missing blob requested %d times, want 1", len(ranges))
+	}
+}
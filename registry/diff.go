@@ -0,0 +1,290 @@
+package registry
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+
+	"dockdiver/client"
+	"dockdiver/layer"
+	"dockdiver/utils"
+)
+
+// imageConfig is the subset of an image config blob compared by DiffImages
+type imageConfig struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Config       struct {
+		User         string              `json:"User"`
+		Env          []string            `json:"Env"`
+		Entrypoint   []string            `json:"Entrypoint"`
+		Cmd          []string            `json:"Cmd"`
+		WorkingDir   string              `json:"WorkingDir"`
+		Labels       map[string]string   `json:"Labels"`
+		ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+	} `json:"config"`
+}
+
+// fileInfo describes a regular file, symlink or other entry of an image filesystem
+type fileInfo struct {
+	Size   int64  `json:"size"`
+	Mode   int64  `json:"mode"`
+	Digest string `json:"digest,omitempty"`
+	Link   string `json:"link,omitempty"`
+}
+
+// ConfigChange is a differing field of two image configs
+type ConfigChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// FileChange is a path that differs between two image filesystems
+type FileChange struct {
+	Path       string `json:"path"`
+	FromSize   int64  `json:"fromSize,omitempty"`
+	ToSize     int64  `json:"toSize,omitempty"`
+	FromDigest string `json:"fromDigest,omitempty"`
+	ToDigest   string `json:"toDigest,omitempty"`
+	FromMode   int64  `json:"fromMode,omitempty"`
+	ToMode     int64  `json:"toMode,omitempty"`
+	FromLink   string `json:"fromLink,omitempty"`
+	ToLink     string `json:"toLink,omitempty"`
+}
+
+// ImageDiff is the result of comparing two images
+type ImageDiff struct {
+	From         string         `json:"from"`
+	To           string         `json:"to"`
+	Config       []ConfigChange `json:"config"`
+	SharedLayers []string       `json:"sharedLayers"`
+	OnlyInFrom   []string       `json:"onlyInFrom"`
+	OnlyInTo     []string       `json:"onlyInTo"`
+	Added        []FileChange   `json:"added"`
+	Removed      []FileChange   `json:"removed"`
+	Modified     []FileChange   `json:"modified"`
+}
+
+// ParseImageRef splits "repo:tag" into its parts. The tag is empty when not given.
+func ParseImageRef(ref string) (string, string) {
+	slash := strings.LastIndex(ref, "/")
+	if colon := strings.LastIndex(ref, ":"); colon > slash {
+		return ref[:colon], ref[colon+1:]
+	}
+	return ref, ""
+}
+
+// DiffImages compares the configs, layer sets and file trees of two images
+// and stores the result as a JSON report in outputDir
+func DiffImages(ep Endpoint, from, to string, auth client.AuthConfig, outputDir string, cli *client.Client) (*ImageDiff, error) {
+	diff := &ImageDiff{From: from, To: to}
+
+	// Layers shared by both images are only downloaded once
+	layerCache := make(map[string][]layer.Change)
+	var manifests [2]*imageManifest
+	var configs [2]*imageConfig
+	var trees [2]*layer.Tree
+	for i, ref := range []string{from, to} {
+		repo, tag := ParseImageRef(ref)
+		if tag == "" {
+			tags, err := fetchTags(ep, repo, auth, cli)
+			if err != nil {
+				return nil, err
+			}
+			tag = tags[0]
+		}
+		manifest, _, err := fetchManifest(ep, repo, tag, auth, cli)
+		if err != nil {
+			return nil, err
+		}
+		manifests[i] = manifest
+
+		configs[i], err = fetchImageConfig(ep, repo, manifest.Config.Digest, auth, cli)
+		if err != nil {
+			return nil, err
+		}
+
+		trees[i] = layer.NewTree()
+		for _, l := range manifest.Layers {
+			changes, ok := layerCache[l.Digest]
+			if !ok {
+				changes, err = fetchLayerChanges(ep, repo, l.Digest, auth, cli)
+				if err != nil {
+					return nil, err
+				}
+				layerCache[l.Digest] = changes
+			}
+			trees[i].Apply(changes)
+		}
+	}
+
+	diff.Config = diffConfigs(configs[0], configs[1])
+	diff.SharedLayers, diff.OnlyInFrom, diff.OnlyInTo = diffLayers(manifests[0], manifests[1])
+	diff.Added, diff.Removed, diff.Modified = diffTrees(trees[0], trees[1])
+
+	data, err := json.MarshalIndent(diff, "", "  ")
+	if err != nil {
+		return diff, fmt.Errorf("failed to encode diff: %v", err)
+	}
+	safeName := strings.NewReplacer("/", "_", ":", "_").Replace(fmt.Sprintf("diff_%s_%s.json", from, to))
+	if err := utils.StoreResponse(filepath.Join(outputDir, safeName), data); err != nil {
+		return diff, fmt.Errorf("failed to store diff: %v", err)
+	}
+	return diff, nil
+}
+
+// fetchImageConfig downloads and parses the config blob of an image
+func fetchImageConfig(ep Endpoint, repo, digest string, auth client.AuthConfig, cli *client.Client) (*imageConfig, error) {
+	config := &imageConfig{}
+	if digest == "" {
+		return config, nil
+	}
+	blobURL := ep.V2("%s/blobs/%s", repo, digest)
+	resp, err := cli.MakeRequest(blobURL, auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch config %s of %s: %v", digest, repo, err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(config); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s of %s: %v", digest, repo, err)
+	}
+	return config, nil
+}
+
+// fetchLayerChanges streams a layer blob and hashes every regular file in it
+func fetchLayerChanges(ep Endpoint, repo, digest string, auth client.AuthConfig, cli *client.Client) ([]layer.Change, error) {
+	blobURL := ep.V2("%s/blobs/%s", repo, digest)
+	fmt.Printf("%s Reading layer: %s\n", color.New(color.FgYellow).SprintFunc()("[!]"), blobURL)
+	resp, err := cli.MakeRequest(blobURL, auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch layer %s of %s: %v", digest, repo, err)
+	}
+	defer resp.Body.Close()
+
+	changes, err := layer.ReadChanges(resp.Body, func(name string, hdr *tar.Header, r io.Reader) (interface{}, error) {
+		info := fileInfo{Size: hdr.Size, Mode: hdr.Mode, Link: hdr.Linkname}
+		if hdr.Typeflag == tar.TypeReg {
+			hash := sha256.New()
+			if _, err := io.Copy(hash, r); err != nil {
+				return nil, err
+			}
+			info.Digest = "sha256:" + hex.EncodeToString(hash.Sum(nil))
+		}
+		return info, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read layer %s of %s: %v", digest, repo, err)
+	}
+	return changes, nil
+}
+
+func diffConfigs(a, b *imageConfig) []ConfigChange {
+	changes := []ConfigChange{}
+	compare := func(field, from, to string) {
+		if from != to {
+			changes = append(changes, ConfigChange{Field: field, From: from, To: to})
+		}
+	}
+	compare("Architecture", a.Architecture, b.Architecture)
+	compare("OS", a.OS, b.OS)
+	compare("User", a.Config.User, b.Config.User)
+	compare("WorkingDir", a.Config.WorkingDir, b.Config.WorkingDir)
+	compare("Entrypoint", strings.Join(a.Config.Entrypoint, " "), strings.Join(b.Config.Entrypoint, " "))
+	compare("Cmd", strings.Join(a.Config.Cmd, " "), strings.Join(b.Config.Cmd, " "))
+	compare("ExposedPorts", strings.Join(sortedKeys(a.Config.ExposedPorts), " "), strings.Join(sortedKeys(b.Config.ExposedPorts), " "))
+
+	envA, envB := envMap(a.Config.Env), envMap(b.Config.Env)
+	for _, key := range unionKeys(envA, envB) {
+		compare("Env."+key, envA[key], envB[key])
+	}
+	for _, key := range unionKeys(a.Config.Labels, b.Config.Labels) {
+		compare("Label."+key, a.Config.Labels[key], b.Config.Labels[key])
+	}
+	return changes
+}
+
+func diffLayers(a, b *imageManifest) (shared, onlyA, onlyB []string) {
+	shared, onlyA, onlyB = []string{}, []string{}, []string{}
+	inB := make(map[string]bool)
+	for _, l := range b.Layers {
+		inB[l.Digest] = true
+	}
+	inA := make(map[string]bool)
+	for _, l := range a.Layers {
+		inA[l.Digest] = true
+		if inB[l.Digest] {
+			shared = append(shared, l.Digest)
+		} else {
+			onlyA = append(onlyA, l.Digest)
+		}
+	}
+	for _, l := range b.Layers {
+		if !inA[l.Digest] {
+			onlyB = append(onlyB, l.Digest)
+		}
+	}
+	return shared, onlyA, onlyB
+}
+
+func diffTrees(a, b *layer.Tree) (added, removed, modified []FileChange) {
+	added, removed, modified = []FileChange{}, []FileChange{}, []FileChange{}
+	for _, p := range a.Paths() {
+		from := a.Files[p].(fileInfo)
+		to, ok := b.Files[p]
+		if !ok {
+			removed = append(removed, FileChange{Path: p, FromSize: from.Size, FromDigest: from.Digest, FromMode: from.Mode, FromLink: from.Link})
+			continue
+		}
+		if toInfo := to.(fileInfo); toInfo != from {
+			modified = append(modified, FileChange{
+				Path: p, FromSize: from.Size, ToSize: toInfo.Size, FromDigest: from.Digest, ToDigest: toInfo.Digest,
+				FromMode: from.Mode, ToMode: toInfo.Mode, FromLink: from.Link, ToLink: toInfo.Link,
+			})
+		}
+	}
+	for _, p := range b.Paths() {
+		if _, ok := a.Files[p]; !ok {
+			to := b.Files[p].(fileInfo)
+			added = append(added, FileChange{Path: p, ToSize: to.Size, ToDigest: to.Digest, ToMode: to.Mode, ToLink: to.Link})
+		}
+	}
+	return added, removed, modified
+}
+
+func envMap(env []string) map[string]string {
+	m := make(map[string]string)
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		m[key] = value
+	}
+	return m
+}
+
+func unionKeys(a, b map[string]string) []string {
+	seen := make(map[string]struct{})
+	for k := range a {
+		seen[k] = struct{}{}
+	}
+	for k := range b {
+		seen[k] = struct{}{}
+	}
+	return sortedKeys(seen)
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,53 @@
+package registry
+
+import (
+	"reflect"
+	"testing"
+
+	"dockdiver/layer"
+)
+
+func TestDiffTrees(t *testing.T) {
+	tree := func(files map[string]fileInfo) *layer.Tree {
+		tr := layer.NewTree()
+		var changes []layer.Change
+		for p, info := range files {
+			changes = append(changes, layer.Change{Path: p, Value: info})
+		}
+		tr.Apply(changes)
+		return tr
+	}
+	from := tree(map[string]fileInfo{
+		"/usr/bin/tool":    {Size: 3, Mode: 0755, Digest: "sha256:a"},
+		"/etc/shadow":      {Size: 5, Mode: 0640, Digest: "sha256:b"},
+		"/etc/app.conf":    {Size: 2, Mode: 0644, Digest: "sha256:c"},
+		"/usr/lib/libx.so": {Mode: 0777, Link: "libx.so.1"},
+		"/opt/removed":     {Size: 1, Mode: 0644, Digest: "sha256:d"},
+	})
+	to := tree(map[string]fileInfo{
+		"/usr/bin/tool":    {Size: 3, Mode: 04755, Digest: "sha256:a"},
+		"/etc/shadow":      {Size: 5, Mode: 0644, Digest: "sha256:b"},
+		"/etc/app.conf":    {Size: 4, Mode: 0644, Digest: "sha256:e"},
+		"/usr/lib/libx.so": {Mode: 0777, Link: "libx.so.2"},
+		"/opt/added":       {Size: 1, Mode: 0600, Digest: "sha256:f"},
+	})
+
+	added, removed, modified := diffTrees(from, to)
+	wantAdded := []FileChange{{Path: "/opt/added", ToSize: 1, ToDigest: "sha256:f", ToMode: 0600}}
+	wantRemoved := []FileChange{{Path: "/opt/removed", FromSize: 1, FromDigest: "sha256:d", FromMode: 0644}}
+	wantModified := []FileChange{
+		{Path: "/etc/app.conf", FromSize: 2, ToSize: 4, FromDigest: "sha256:c", ToDigest: "sha256:e", FromMode: 0644, ToMode: 0644},
+		{Path: "/etc/shadow", FromSize: 5, ToSize: 5, FromDigest: "sha256:b", ToDigest: "sha256:b", FromMode: 0640, ToMode: 0644},
+		{Path: "/usr/bin/tool", FromSize: 3, ToSize: 3, FromDigest: "sha256:a", ToDigest: "sha256:a", FromMode: 0755, ToMode: 04755},
+		{Path: "/usr/lib/libx.so", FromMode: 0777, ToMode: 0777, FromLink: "libx.so.1", ToLink: "libx.so.2"},
+	}
+	if !reflect.DeepEqual(added, wantAdded) {
+		t.Errorf("added = %+v, want %+v", added, wantAdded)
+	}
+	if !reflect.DeepEqual(removed, wantRemoved) {
+		t.Errorf("removed = %+v, want %+v", removed, wantRemoved)
+	}
+	if !reflect.DeepEqual(modified, wantModified) {
+		t.Errorf("modified = %+v, want %+v", modified, wantModified)
+	}
+}
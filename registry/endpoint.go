@@ -0,0 +1,110 @@
+package registry
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Endpoint locates a registry: scheme, host, port and the path prefix the
+// registry is mounted under, if any
+type Endpoint struct {
+	Scheme   string // "http" or "https", empty when it still has to be probed
+	Host     string // Hostname or IP address, IPv6 without brackets
+	Port     int
+	BasePath string // e.g. "/registry", without a trailing slash or /v2
+}
+
+// ParseEndpoint parses a registry URL or bare host such as
+// https://host:8443/registry, host:5000, [fd00::1]:5000 or fd00::1.
+// defaultPort is used when the input does not name a port.
+func ParseEndpoint(raw string, defaultPort int) (Endpoint, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return Endpoint{}, fmt.Errorf("empty registry URL")
+	}
+	// A bare IPv6 address has colons that would otherwise read as a port
+	if ip := net.ParseIP(raw); ip != nil && strings.Contains(raw, ":") {
+		raw = "[" + raw + "]"
+	}
+	scheme := ""
+	if i := strings.Index(raw, "://"); i >= 0 {
+		scheme = strings.ToLower(raw[:i])
+		raw = raw[i+3:]
+	}
+	if scheme != "" && scheme != "http" && scheme != "https" {
+		return Endpoint{}, fmt.Errorf("unsupported scheme '%s'; use 'http' or 'https'", scheme)
+	}
+
+	u, err := url.Parse("//" + raw)
+	if err != nil {
+		return Endpoint{}, fmt.Errorf("invalid URL format: %v", err)
+	}
+	if u.Hostname() == "" {
+		return Endpoint{}, fmt.Errorf("invalid URL %q: missing host", raw)
+	}
+
+	ep := Endpoint{Scheme: scheme, Host: u.Hostname(), Port: defaultPort}
+	if p := u.Port(); p != "" {
+		ep.Port, err = strconv.Atoi(p)
+		if err != nil || ep.Port < 1 || ep.Port > 65535 {
+			return Endpoint{}, fmt.Errorf("invalid port in URL: %s", p)
+		}
+	}
+
+	// Accept URLs copied with the API root, e.g. https://host/registry/v2/
+	basePath := strings.TrimRight(u.Path, "/")
+	basePath = strings.TrimSuffix(basePath, "/v2")
+	ep.BasePath = strings.TrimRight(basePath, "/")
+	return ep, nil
+}
+
+// WithScheme returns a copy of the endpoint using scheme
+func (e Endpoint) WithScheme(scheme string) Endpoint {
+	e.Scheme = scheme
+	return e
+}
+
+// Address returns host:port, bracketing IPv6 addresses
+func (e Endpoint) Address() string {
+	return net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+}
+
+// Base returns the registry root, e.g. https://[fd00::1]:5000/registry
+func (e Endpoint) Base() string {
+	return e.Origin() + e.BasePath
+}
+
+// String returns the registry root
+func (e Endpoint) String() string {
+	return e.Base()
+}
+
+// V2 returns the URL of an API path relative to /v2/, e.g.
+// V2("%s/tags/list", repo). A trailing query string is kept as is.
+func (e Endpoint) V2(format string, args ...interface{}) string {
+	return e.Base() + "/v2/" + fmt.Sprintf(format, args...)
+}
+
+// Resolve turns a reference returned by the registry, such as a Link header
+// target, into an absolute URL. Absolute paths are relative to the host,
+// not the base path, as they already include it.
+func (e Endpoint) Resolve(ref string) (string, error) {
+	base, err := url.Parse(e.Base() + "/v2/")
+	if err != nil {
+		return "", err
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid reference %q: %v", ref, err)
+	}
+	return base.ResolveReference(r).String(), nil
+}
+
+// Origin returns scheme://host:port without the base path, where the web
+// API of the product serving the registry usually lives
+func (e Endpoint) Origin() string {
+	return e.Scheme + "://" + e.Address()
+}
@@ -0,0 +1,104 @@
+package registry
+
+import "testing"
+
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    Endpoint
+		base    string
+		wantErr bool
+	}{
+		{
+			raw:  "registry.example.com",
+			want: Endpoint{Host: "registry.example.com", Port: 5000},
+		},
+		{
+			raw:  "https://registry.example.com",
+			want: Endpoint{Scheme: "https", Host: "registry.example.com", Port: 5000},
+			base: "https://registry.example.com:5000",
+		},
+		{
+			raw:  "HTTP://registry.example.com:8080/",
+			want: Endpoint{Scheme: "http", Host: "registry.example.com", Port: 8080},
+			base: "http://registry.example.com:8080",
+		},
+		{
+			raw:  " https://host:8443/registry/v2/ ",
+			want: Endpoint{Scheme: "https", Host: "host", Port: 8443, BasePath: "/registry"},
+			base: "https://host:8443/registry",
+		},
+		{
+			raw:  "https://host/v2",
+			want: Endpoint{Scheme: "https", Host: "host", Port: 5000},
+		},
+		{
+			raw:  "host/repository/docker-hosted",
+			want: Endpoint{Host: "host", Port: 5000, BasePath: "/repository/docker-hosted"},
+		},
+		{
+			raw:  "[fd00::1]:5001",
+			want: Endpoint{Host: "fd00::1", Port: 5001},
+		},
+		{
+			raw:  "fd00::1",
+			want: Endpoint{Host: "fd00::1", Port: 5000},
+		},
+		{
+			raw:  "https://[fd00::1]/registry",
+			want: Endpoint{Scheme: "https", Host: "fd00::1", Port: 5000, BasePath: "/registry"},
+			base: "https://[fd00::1]:5000/registry",
+		},
+		{
+			raw:  "192.0.2.10:443",
+			want: Endpoint{Host: "192.0.2.10", Port: 443},
+		},
+		{raw: "", wantErr: true},
+		{raw: "ftp://host", wantErr: true},
+		{raw: "https://", wantErr: true},
+		{raw: "host:0", wantErr: true},
+		{raw: "host:65536", wantErr: true},
+		{raw: "host:http", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := ParseEndpoint(tt.raw, 5000)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseEndpoint(%q) = %+v, want an error", tt.raw, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseEndpoint(%q): %v", tt.raw, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseEndpoint(%q) = %+v, want %+v", tt.raw, got, tt.want)
+			}
+			if tt.base != "" && got.Base() != tt.base {
+				t.Errorf("Base() = %q, want %q", got.Base(), tt.base)
+			}
+		})
+	}
+}
+
+func TestEndpointResolve(t *testing.T) {
+	ep := Endpoint{Scheme: "https", Host: "host", Port: 443, BasePath: "/registry"}
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"/registry/v2/_catalog?last=b&n=100", "https://host:443/registry/v2/_catalog?last=b&n=100"},
+		{"_catalog?last=b", "https://host:443/registry/v2/_catalog?last=b"},
+		{"https://mirror/v2/_catalog", "https://mirror/v2/_catalog"},
+	}
+	for _, tt := range tests {
+		got, err := ep.Resolve(tt.ref)
+		if err != nil {
+			t.Fatalf("Resolve(%q): %v", tt.ref, err)
+		}
+		if got != tt.want {
+			t.Errorf("Resolve(%q) = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
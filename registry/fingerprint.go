@@ -0,0 +1,373 @@
+package registry
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"dockdiver/client"
+)
+
+// Registry products recognised by FingerprintRegistry
+const (
+	ProductDistribution = "CNCF Distribution"
+	ProductHarbor       = "Harbor"
+	ProductNexus        = "Sonatype Nexus"
+	ProductArtifactory  = "JFrog Artifactory"
+	ProductGitLab       = "GitLab Container Registry"
+	ProductQuay         = "Quay"
+	ProductGitea        = "Gitea"
+	ProductZot          = "Zot"
+	ProductECR          = "Amazon ECR"
+	ProductDockerHub    = "Docker Hub"
+)
+
+// productHints lists the enumeration and authentication paths worth trying
+// once a product is known
+var productHints = map[string][]string{
+	ProductDistribution: {
+		"_catalog lists every repository the credentials can pull",
+		"Authentication is htpasswd Basic auth or an external token server named in the realm",
+	},
+	ProductHarbor: {
+		"The REST API under /api/v2.0 lists projects and repositories, including ones _catalog hides",
+		"Registry tokens come from /service/token; robot accounts are named robot$<name>",
+	},
+	ProductNexus: {
+		"/service/rest/v1/repositories and /service/rest/v1/search?format=docker list Docker repositories",
+		"Docker repositories usually listen on their own connector ports or under /repository/<name>",
+	},
+	ProductArtifactory: {
+		"/artifactory/api/repositories?packageType=docker lists Docker repositories",
+		"Each repository has its own API root at /artifactory/api/docker/<repo>/v2/, and anonymous access may be enabled",
+	},
+	ProductGitLab: {
+		"Registry tokens come from /jwt/auth on the GitLab host; personal, deploy and CI job tokens work as passwords",
+		"_catalog is usually restricted to admins; repository names follow <group>/<project>[/<image>]",
+	},
+	ProductQuay: {
+		"/api/v1/repository?public=true and /api/v1/find/repositories list repositories",
+		"Robot accounts are named <org>+<name>; _catalog only returns what the token can see",
+	},
+	ProductGitea: {
+		"/api/v1/packages/<owner>?type=container lists images of a user or organisation",
+		"Access tokens work as registry passwords; repository names are <owner>/<image>",
+	},
+	ProductZot: {
+		"The search extension at /v2/_zot/ext/search (GraphQL) lists images and tags when enabled",
+		"/v2/_zot/ext/mgmt reports the configured authentication methods",
+	},
+	ProductECR: {
+		"Basic credentials are AWS:<token> from 'aws ecr get-login-password'",
+		"_catalog is not supported; repository names have to come from the ECR API or be guessed",
+	},
+	ProductDockerHub: {
+		"_catalog is disabled; tokens from auth.docker.io are scoped to single repositories",
+	},
+}
+
+// Fingerprint is the best guess at the product serving a registry
+type Fingerprint struct {
+	Product    string   `json:"product"`
+	Version    string   `json:"version,omitempty"`
+	Confidence string   `json:"confidence"` // "high", "medium" or "low"
+	Evidence   []string `json:"evidence"`
+	Hints      []string `json:"hints,omitempty"`
+	Others     []string `json:"others,omitempty"` // Other products with some evidence
+}
+
+// fingerprinter accumulates evidence for each product
+type fingerprinter struct {
+	scores   map[string]int
+	versions map[string]string
+	evidence map[string][]string
+}
+
+func newFingerprinter() *fingerprinter {
+	return &fingerprinter{
+		scores:   make(map[string]int),
+		versions: make(map[string]string),
+		evidence: make(map[string][]string),
+	}
+}
+
+func (f *fingerprinter) add(product string, score int, evidence string) {
+	f.scores[product] += score
+	f.evidence[product] = append(f.evidence[product], evidence)
+}
+
+func (f *fingerprinter) version(product, version string) {
+	if version != "" && f.versions[product] == "" {
+		f.versions[product] = version
+	}
+}
+
+// FingerprintRegistry identifies the product behind a registry from the
+// headers and auth challenge of /v2/, the error format for an unknown
+// repository and the well-known API endpoints of each product
+func FingerprintRegistry(ep Endpoint, auth client.AuthConfig, cli *client.Client) (*Fingerprint, error) {
+	f := newFingerprinter()
+
+	resp, _, err := cli.Probe("GET", ep.V2(""), auth, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query /v2/: %w", err)
+	}
+	f.headers(ep, resp.Header)
+
+	f.errorFormat(ep, auth, cli)
+	f.wellKnown(ep, auth, cli)
+
+	return f.result(), nil
+}
+
+// headers inspects product headers and the WWW-Authenticate challenge
+func (f *fingerprinter) headers(ep Endpoint, h http.Header) {
+	if v := h.Get("Docker-Distribution-Api-Version"); v != "" {
+		f.add(ProductDistribution, 1, "Docker-Distribution-Api-Version: "+v)
+	}
+	if v := h.Get("Gitlab-Container-Registry-Version"); v != "" {
+		f.add(ProductGitLab, 5, "Gitlab-Container-Registry-Version: "+v)
+		f.version(ProductGitLab, v)
+	}
+	if v := h.Get("X-Jfrog-Version"); v != "" {
+		f.add(ProductArtifactory, 5, "X-JFrog-Version: "+v)
+		// "Artifactory/7.77.5 77705900" carries the build number after the version
+		version, _, _ := strings.Cut(strings.TrimPrefix(v, "Artifactory/"), " ")
+		f.version(ProductArtifactory, version)
+	}
+	if h.Get("X-Artifactory-Id") != "" || h.Get("X-Artifactory-Node-Id") != "" {
+		f.add(ProductArtifactory, 5, "X-Artifactory-Id header")
+	}
+	if h.Get("X-Harbor-Csrf-Token") != "" {
+		f.add(ProductHarbor, 3, "X-Harbor-CSRF-Token header")
+	}
+	if server := h.Get("Server"); server != "" {
+		name, version, _ := strings.Cut(server, "/")
+		switch strings.ToLower(name) {
+		case "nexus":
+			f.add(ProductNexus, 5, "Server: "+server)
+			f.version(ProductNexus, nexusVersion(server))
+		case "artifactory":
+			f.add(ProductArtifactory, 5, "Server: "+server)
+			f.version(ProductArtifactory, version)
+		}
+	}
+	if strings.Contains(ep.Host, ".dkr.ecr.") {
+		f.add(ProductECR, 3, "host name "+ep.Host)
+	}
+
+	for _, header := range h.Values("Www-Authenticate") {
+		challenge, ok := client.ParseChallenge(header)
+		if !ok {
+			continue
+		}
+		f.challenge(challenge, header)
+	}
+}
+
+// nexusVersion extracts 3.61.0-02 from a "Nexus/3.61.0-02 (OSS)" Server header
+func nexusVersion(server string) string {
+	name, version, ok := strings.Cut(server, "/")
+	if !ok || !strings.EqualFold(name, "nexus") {
+		return ""
+	}
+	version, _, _ = strings.Cut(version, " ")
+	return version
+}
+
+// challenge matches the realm and service of an auth challenge
+func (f *fingerprinter) challenge(c client.Challenge, header string) {
+	realm := c.Params["realm"]
+	service := c.Params["service"]
+	evidence := "WWW-Authenticate: " + header
+
+	if c.Scheme == "basic" {
+		switch {
+		case realm == "Registry Realm":
+			f.add(ProductDistribution, 2, evidence)
+		case strings.Contains(realm, "Sonatype Nexus"):
+			f.add(ProductNexus, 4, evidence)
+		case strings.Contains(realm, "Artifactory"):
+			f.add(ProductArtifactory, 4, evidence)
+		case strings.EqualFold(realm, "zot"):
+			f.add(ProductZot, 2, evidence)
+		case service == "ecr.amazonaws.com" || strings.Contains(realm, ".dkr.ecr."):
+			f.add(ProductECR, 5, evidence)
+		}
+		return
+	}
+	if c.Scheme != "bearer" {
+		return
+	}
+
+	path := ""
+	if u, err := url.Parse(realm); err == nil {
+		path = strings.TrimRight(u.Path, "/")
+		if u.Hostname() == "auth.docker.io" {
+			f.add(ProductDockerHub, 5, evidence)
+			return
+		}
+	}
+	switch {
+	case service == "harbor-registry" || strings.HasSuffix(path, "/service/token"):
+		f.add(ProductHarbor, 4, evidence)
+	case strings.HasSuffix(path, "/jwt/auth"):
+		f.add(ProductGitLab, 4, evidence)
+	case strings.Contains(path, "/artifactory/"):
+		f.add(ProductArtifactory, 4, evidence)
+	case strings.HasSuffix(path, "/v2/token") && service == "container_registry":
+		f.add(ProductGitea, 4, evidence)
+	case strings.HasSuffix(path, "/v2/auth"):
+		f.add(ProductQuay, 3, evidence)
+	case strings.Contains(path, "/nexus/") || strings.Contains(path, "/repository/"):
+		f.add(ProductNexus, 2, evidence)
+	}
+}
+
+// errorFormat requests the tags of a repository that cannot exist and
+// matches the error body, which differs between products
+func (f *fingerprinter) errorFormat(ep Endpoint, auth client.AuthConfig, cli *client.Client) {
+	resp, body, err := cli.Probe("GET", ep.V2("%s/tags/list", randomRepositoryName()), auth, nil)
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		return
+	}
+	var parsed struct {
+		Errors []client.ErrorDetail `json:"errors"`
+	}
+	if json.Unmarshal(body, &parsed) != nil || len(parsed.Errors) == 0 {
+		return
+	}
+	e := parsed.Errors[0]
+	evidence := fmt.Sprintf("unknown repository error %s: %s", e.Code, e.Message)
+	switch {
+	case e.Code == "NOT_FOUND":
+		// Harbor answers from its own API layer before reaching distribution
+		f.add(ProductHarbor, 2, evidence)
+	case e.Code == client.CodeNameUnknown && e.Message == "repository name not known to registry":
+		f.add(ProductDistribution, 1, evidence)
+	case e.Code == client.CodeNameUnknown && strings.Contains(e.Message, "repository not found"):
+		f.add(ProductQuay, 1, evidence)
+	}
+}
+
+// randomRepositoryName returns a repository name that will not exist
+func randomRepositoryName() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "dockdiver-" + hex.EncodeToString(b)
+}
+
+// wellKnown queries the web API endpoints that identify each product and
+// often report its version. They live at the origin, not the base path.
+func (f *fingerprinter) wellKnown(ep Endpoint, auth client.AuthConfig, cli *client.Client) {
+	get := func(path string) (int, []byte, http.Header) {
+		resp, body, err := cli.Probe("GET", ep.Origin()+path, auth, nil)
+		if err != nil {
+			return 0, nil, nil
+		}
+		return resp.StatusCode, body, resp.Header
+	}
+	versionField := func(body []byte, field string) (string, bool) {
+		var parsed map[string]interface{}
+		if json.Unmarshal(body, &parsed) != nil {
+			return "", false
+		}
+		v, ok := parsed[field].(string)
+		return v, ok
+	}
+
+	if status, body, _ := get("/api/v2.0/systeminfo"); status == http.StatusOK {
+		if v, ok := versionField(body, "harbor_version"); ok {
+			f.add(ProductHarbor, 5, "/api/v2.0/systeminfo reports harbor_version "+v)
+			f.version(ProductHarbor, v)
+		}
+	}
+
+	if status, _, h := get("/service/rest/v1/status"); status == http.StatusOK {
+		f.add(ProductNexus, 3, "/service/rest/v1/status answers 200")
+		f.version(ProductNexus, nexusVersion(h.Get("Server")))
+	}
+
+	if status, body, _ := get("/artifactory/api/system/version"); status == http.StatusOK {
+		if v, ok := versionField(body, "version"); ok {
+			f.add(ProductArtifactory, 5, "/artifactory/api/system/version reports "+v)
+			f.version(ProductArtifactory, v)
+		}
+	} else if status, body, _ := get("/artifactory/api/system/ping"); status == http.StatusOK && strings.TrimSpace(string(body)) == "OK" {
+		f.add(ProductArtifactory, 3, "/artifactory/api/system/ping answers OK")
+	}
+
+	if status, body, _ := get("/api/v4/version"); status == http.StatusOK {
+		if v, ok := versionField(body, "version"); ok {
+			f.add(ProductGitLab, 5, "/api/v4/version reports "+v)
+			f.version(ProductGitLab, v)
+		}
+	} else if status == http.StatusUnauthorized && strings.Contains(string(body), "401 Unauthorized") {
+		f.add(ProductGitLab, 1, "/api/v4/version requires authentication")
+	}
+
+	if status, body, _ := get("/api/v1/discovery"); status == http.StatusOK && strings.Contains(string(body), "Quay") {
+		f.add(ProductQuay, 4, "/api/v1/discovery describes the Quay API")
+	}
+
+	if status, body, _ := get("/api/v1/version"); status == http.StatusOK {
+		if v, ok := versionField(body, "version"); ok {
+			f.add(ProductGitea, 4, "/api/v1/version reports "+v)
+			f.version(ProductGitea, v)
+		}
+	}
+
+	if status, body, _ := get("/v2/_zot/ext/discover"); status == http.StatusOK && len(body) > 0 {
+		f.add(ProductZot, 5, "/v2/_zot/ext/discover answers 200")
+	}
+}
+
+// result picks the product with the most evidence. CNCF Distribution is
+// the fallback, as most products embed it and share its headers.
+func (f *fingerprinter) result() *Fingerprint {
+	var products []string
+	for p := range f.scores {
+		products = append(products, p)
+	}
+	sort.Slice(products, func(i, j int) bool {
+		si, sj := f.scores[products[i]], f.scores[products[j]]
+		if si != sj {
+			return si > sj
+		}
+		// On a tie, prefer the specific product over the distribution fallback
+		if products[i] == ProductDistribution || products[j] == ProductDistribution {
+			return products[j] == ProductDistribution
+		}
+		return products[i] < products[j]
+	})
+
+	if len(products) == 0 {
+		return &Fingerprint{Product: "unknown", Confidence: "low"}
+	}
+	best := products[0]
+	confidence := "low"
+	switch score := f.scores[best]; {
+	case score >= 5:
+		confidence = "high"
+	case score >= 3:
+		confidence = "medium"
+	}
+	fp := &Fingerprint{
+		Product:    best,
+		Version:    f.versions[best],
+		Confidence: confidence,
+		Evidence:   f.evidence[best],
+		Hints:      productHints[best],
+	}
+	for _, p := range products[1:] {
+		if p != ProductDistribution {
+			fp.Others = append(fp.Others, p)
+		}
+	}
+	return fp
+}
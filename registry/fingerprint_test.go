@@ -0,0 +1,189 @@
+package registry
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"dockdiver/client"
+)
+
+func TestFingerprintHeaders(t *testing.T) {
+	tests := []struct {
+		name           string
+		host           string
+		header         http.Header
+		wantProduct    string
+		wantVersion    string
+		wantConfidence string
+		wantOthers     []string
+	}{
+		{
+			name: "Harbor",
+			header: http.Header{
+				"Docker-Distribution-Api-Version": {"registry/2.0"},
+				"Www-Authenticate":                {`Bearer realm="https://harbor.example.com/service/token",service="harbor-registry"`},
+			},
+			wantProduct:    ProductHarbor,
+			wantConfidence: "medium",
+		},
+		{
+			name: "GitLab",
+			header: http.Header{
+				"Docker-Distribution-Api-Version":    {"registry/2.0"},
+				"Gitlab-Container-Registry-Version":  {"v4.14.0-gitlab"},
+				"Gitlab-Container-Registry-Features": {"tag_delete"},
+				"Www-Authenticate":                   {`Bearer realm="https://gitlab.example.com/jwt/auth",service="container_registry"`},
+			},
+			wantProduct:    ProductGitLab,
+			wantVersion:    "v4.14.0-gitlab",
+			wantConfidence: "high",
+		},
+		{
+			name: "Nexus",
+			header: http.Header{
+				"Docker-Distribution-Api-Version": {"registry/2.0"},
+				"Server":                          {"Nexus/3.61.0-02 (OSS)"},
+				"Www-Authenticate":                {`BASIC realm="Sonatype Nexus Repository Manager"`},
+			},
+			wantProduct:    ProductNexus,
+			wantVersion:    "3.61.0-02",
+			wantConfidence: "high",
+		},
+		{
+			name: "Artifactory",
+			header: http.Header{
+				"Docker-Distribution-Api-Version": {"registry/2.0"},
+				"X-Jfrog-Version":                 {"Artifactory/7.77.5 77705900"},
+				"X-Artifactory-Id":                {"a1b2c3d4e5f6"},
+				"Www-Authenticate":                {`Bearer realm="https://jfrog.example.com/artifactory/api/docker/docker-local/v2/token",service="jfrog.example.com"`},
+			},
+			wantProduct:    ProductArtifactory,
+			wantVersion:    "7.77.5",
+			wantConfidence: "high",
+		},
+		{
+			name: "Distribution with htpasswd",
+			header: http.Header{
+				"Docker-Distribution-Api-Version": {"registry/2.0"},
+				"Www-Authenticate":                {`Basic realm="Registry Realm"`},
+			},
+			wantProduct:    ProductDistribution,
+			wantConfidence: "medium",
+		},
+		{
+			name: "Distribution with an external token server",
+			header: http.Header{
+				"Docker-Distribution-Api-Version": {"registry/2.0"},
+				"Www-Authenticate":                {`Bearer realm="https://auth.example.com/token",service="Docker registry"`},
+			},
+			wantProduct:    ProductDistribution,
+			wantConfidence: "low",
+		},
+		{
+			name: "tie with Distribution goes to the specific product",
+			header: http.Header{
+				"Docker-Distribution-Api-Version": {"registry/2.0"},
+				"Www-Authenticate":                {`Basic realm="Registry Realm"`},
+				"X-Harbor-Csrf-Token":             {"dG9rZW4="},
+			},
+			wantProduct:    ProductHarbor,
+			wantConfidence: "medium",
+		},
+		{
+			name: "tie between products is broken by name",
+			host: "123456789012.dkr.ecr.eu-west-1.amazonaws.com",
+			header: http.Header{
+				"Www-Authenticate": {`Bearer realm="https://quay.example.com/v2/auth",service="quay.example.com"`},
+			},
+			wantProduct:    ProductECR,
+			wantConfidence: "medium",
+			wantOthers:     []string{ProductQuay},
+		},
+		{
+			name:           "no evidence",
+			header:         http.Header{},
+			wantProduct:    "unknown",
+			wantConfidence: "low",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := tt.host
+			if host == "" {
+				host = "registry.example.com"
+			}
+			f := newFingerprinter()
+			f.headers(mustEndpoint(t, "https://"+host), tt.header)
+			got := f.result()
+			if got.Product != tt.wantProduct || got.Version != tt.wantVersion || got.Confidence != tt.wantConfidence {
+				t.Errorf("result() = %s %q (%s), want %s %q (%s)", got.Product, got.Version, got.Confidence, tt.wantProduct, tt.wantVersion, tt.wantConfidence)
+			}
+			if !reflect.DeepEqual(got.Others, tt.wantOthers) {
+				t.Errorf("Others = %q, want %q", got.Others, tt.wantOthers)
+			}
+			if got.Product != "unknown" && len(got.Evidence) == 0 {
+				t.Error("result() has no evidence")
+			}
+		})
+	}
+}
+
+func TestFingerprintChallenge(t *testing.T) {
+	tests := []struct {
+		header    string
+		want      string // Product credited, empty for none
+		wantScore int
+	}{
+		{`Bearer realm="https://harbor.example.com/service/token",service="harbor-registry"`, ProductHarbor, 4},
+		{`Bearer realm="https://example.com/harbor/service/token/",service="registry"`, ProductHarbor, 4},
+		{`Bearer realm="https://gitlab.example.com/jwt/auth",service="container_registry"`, ProductGitLab, 4},
+		{`Bearer realm="https://jfrog.example.com/artifactory/api/docker/docker-local/v2/token",service="jfrog.example.com"`, ProductArtifactory, 4},
+		{`Bearer realm="https://gitea.example.com/v2/token",service="container_registry",scope="*"`, ProductGitea, 4},
+		{`Bearer realm="https://quay.example.com/v2/auth",service="quay.example.com"`, ProductQuay, 3},
+		{`Bearer realm="https://nexus.example.com/repository/docker-hosted/v2/token",service="docker"`, ProductNexus, 2},
+		{`Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`, ProductDockerHub, 5},
+		{`Bearer realm="https://auth.example.com/v2/token",service="registry"`, "", 0},
+		{`Basic realm="Registry Realm"`, ProductDistribution, 2},
+		{`BASIC realm="Sonatype Nexus Repository Manager"`, ProductNexus, 4},
+		{`Basic realm="Artifactory Realm"`, ProductArtifactory, 4},
+		{`Basic realm="zot"`, ProductZot, 2},
+		{`Basic realm="https://123456789012.dkr.ecr.us-east-1.amazonaws.com/",service="ecr.amazonaws.com"`, ProductECR, 5},
+		{`Basic realm="Restricted"`, "", 0},
+		{`Digest realm="Registry Realm",nonce="abc"`, "", 0},
+	}
+	for _, tt := range tests {
+		c, ok := client.ParseChallenge(tt.header)
+		if !ok {
+			t.Fatalf("ParseChallenge(%q) failed", tt.header)
+		}
+		f := newFingerprinter()
+		f.challenge(c, tt.header)
+		want := map[string]int{}
+		if tt.want != "" {
+			want[tt.want] = tt.wantScore
+		}
+		if !reflect.DeepEqual(f.scores, want) {
+			t.Errorf("challenge(%q) scores = %v, want %v", tt.header, f.scores, want)
+		}
+	}
+}
+
+func TestNexusVersion(t *testing.T) {
+	tests := []struct {
+		server string
+		want   string
+	}{
+		{"Nexus/3.61.0-02 (OSS)", "3.61.0-02"},
+		{"Nexus/3.37.3-02 (PRO)", "3.37.3-02"},
+		{"nexus/3.0.0", "3.0.0"},
+		{"Nexus", ""},
+		{"Artifactory/7.77.5", ""},
+		{"nginx/1.25.3", ""},
+	}
+	for _, tt := range tests {
+		if got := nexusVersion(tt.server); got != tt.want {
+			t.Errorf("nexusVersion(%q) = %q, want %q", tt.server, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"dockdiver/client"
+)
+
+// gitlabPageSize is the page size requested from the GitLab API, its maximum
+const gitlabPageSize = 100
+
+// gitlabCatalog lists repositories through the GitLab REST API under
+// /api/v4: the projects visible to the caller, then the container
+// repositories of each. GitLab serves its API from the main instance, which
+// usually differs from the registry host, hence CatalogOptions.APIBase.
+type gitlabCatalog struct {
+	opts CatalogOptions
+}
+
+func (gitlabCatalog) Name() string { return "gitlab" }
+
+func (c gitlabCatalog) Repositories(ep Endpoint, auth client.AuthConfig, cli Doer) ([]string, error) {
+	base := c.opts.apiBase(ep)
+	auth = gitlabAuth(auth)
+
+	projectsURL := base + "/api/v4/projects?simple=true"
+	if auth != (client.AuthConfig{}) {
+		// Without this, authenticated callers page through every public
+		// project of the instance
+		projectsURL += "&membership=true"
+	}
+	var projects []int
+	err := gitlabList(projectsURL, auth, cli, func(dec *json.Decoder) error {
+		var page []struct {
+			ID int `json:"id"`
+		}
+		err := dec.Decode(&page)
+		for _, p := range page {
+			projects = append(projects, p.ID)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list GitLab projects: %w", err)
+	}
+
+	var names []string
+	for _, id := range projects {
+		reposURL := fmt.Sprintf("%s/api/v4/projects/%d/registry/repositories", base, id)
+		err := gitlabList(reposURL, auth, cli, func(dec *json.Decoder) error {
+			var page []struct {
+				Path string `json:"path"`
+			}
+			err := dec.Decode(&page)
+			for _, r := range page {
+				names = append(names, r.Path)
+			}
+			return err
+		})
+		if err != nil {
+			continue // The registry is disabled or hidden for this project
+		}
+	}
+	return uniqueSorted(names), nil
+}
+
+// gitlabAuth adapts registry credentials to the GitLab API, which takes a
+// personal or deploy token in PRIVATE-TOKEN rather than Basic auth
+func gitlabAuth(auth client.AuthConfig) client.AuthConfig {
+	if auth.Password == "" {
+		return auth
+	}
+	headers := map[string]string{}
+	if auth.Headers != "" {
+		if err := json.Unmarshal([]byte(auth.Headers), &headers); err != nil {
+			return auth // The client reports the invalid headers
+		}
+	}
+	if _, ok := headers["PRIVATE-TOKEN"]; !ok {
+		headers["PRIVATE-TOKEN"] = auth.Password
+	}
+	encoded, _ := json.Marshal(headers)
+	return client.AuthConfig{Bearer: auth.Bearer, Headers: string(encoded)}
+}
+
+// gitlabList requests every page of a GitLab API list, following the
+// X-Next-Page header, and passes each page to decode
+func gitlabList(listURL string, auth client.AuthConfig, cli Doer, decode func(*json.Decoder) error) error {
+	sep := "?"
+	if strings.Contains(listURL, "?") {
+		sep = "&"
+	}
+	header := http.Header{"Accept": {"application/json"}}
+	for page := "1"; page != ""; {
+		pageURL := fmt.Sprintf("%s%sper_page=%d&page=%s", listURL, sep, gitlabPageSize, page)
+		resp, err := cli.Do("GET", pageURL, auth, header)
+		if err != nil {
+			return err
+		}
+		err = decode(json.NewDecoder(resp.Body))
+		page = resp.Header.Get("X-Next-Page")
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to decode %s: %v", pageURL, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package registry
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/fatih/color"
+
+	"dockdiver/client"
+	"dockdiver/layer"
+	"dockdiver/utils"
+)
+
+// GrepOptions controls how layer contents are searched
+type GrepOptions struct {
+	Pattern     *regexp.Regexp
+	MaxFileSize int64           // Files larger than this are skipped
+	Context     int             // Number of lines shown before and after a match
+	Catalogs    []CatalogSource // Sources the repositories are listed with, _catalog when empty
+}
+
+// GrepMatch describes a single matching line inside an image layer
+type GrepMatch struct {
+	Repo   string   `json:"repo"`
+	Tag    string   `json:"tag"`
+	Layer  string   `json:"layer"`
+	Path   string   `json:"path"`
+	Line   int      `json:"line"`
+	Text   string   `json:"text"`
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
+}
+
+// GrepError records a layer that could not be searched to the end. The
+// matches found before the error are still reported.
+type GrepError struct {
+	Repo  string `json:"repo"`
+	Tag   string `json:"tag"`
+	Layer string `json:"layer"`
+	Error string `json:"error"`
+}
+
+// GrepResults is the outcome of a search, stored as grep.json
+type GrepResults struct {
+	Matches []GrepMatch `json:"matches"`
+	Errors  []GrepError `json:"errors,omitempty"`
+}
+
+// GrepRepositories searches the file contents of every layer of every tag in
+// the catalog and stores the matches and unreadable layers as grep.json in
+// outputDir
+func GrepRepositories(ep Endpoint, auth client.AuthConfig, outputDir string, cli *client.Client, opts GrepOptions) (*GrepResults, error) {
+	errorColor := color.New(color.FgRed).SprintFunc()
+
+	repos, err := ListRepositories(ep, auth, cli, opts.Catalogs)
+	if err != nil {
+		return nil, err
+	}
+
+	search := &layerSearch{
+		opts: opts,
+		open: func(repo, digest string) (io.ReadCloser, error) {
+			blobURL := ep.V2("%s/blobs/%s", repo, digest)
+			fmt.Printf("%s Searching layer: %s\n", color.New(color.FgYellow).SprintFunc()("[!]"), blobURL)
+			resp, err := cli.MakeRequest(blobURL, auth)
+			if err != nil {
+				return nil, err
+			}
+			return resp.Body, nil
+		},
+		scanned: make(map[string][]GrepMatch),
+	}
+	results := &GrepResults{Matches: []GrepMatch{}}
+	for _, repo := range repos {
+		tags, err := fetchTags(ep, repo, auth, cli)
+		if err != nil {
+			fmt.Printf("%s %v\n", errorColor("[-]"), err)
+			continue
+		}
+		for _, tag := range tags {
+			manifest, _, err := fetchManifest(ep, repo, tag, auth, cli)
+			if err != nil {
+				fmt.Printf("%s %v\n", errorColor("[-]"), err)
+				continue
+			}
+			for _, l := range manifest.Layers {
+				layerMatches, err := search.layer(repo, l.Digest)
+				if err != nil {
+					fmt.Printf("%s Error searching layer %s of %s:%s: %v\n", errorColor("[-]"), l.Digest, repo, tag, err)
+					results.Errors = append(results.Errors, GrepError{Repo: repo, Tag: tag, Layer: l.Digest, Error: err.Error()})
+				}
+				for _, m := range layerMatches {
+					m.Repo, m.Tag = repo, tag
+					printGrepMatch(m)
+					results.Matches = append(results.Matches, m)
+				}
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return results, fmt.Errorf("failed to encode grep results: %v", err)
+	}
+	if err := utils.StoreResponse(filepath.Join(outputDir, "grep.json"), data); err != nil {
+		return results, fmt.Errorf("failed to store grep results: %v", err)
+	}
+	return results, nil
+}
+
+// layerSearch searches layer blobs, streaming each digest only once since
+// layers are frequently shared between tags
+type layerSearch struct {
+	opts    GrepOptions
+	open    func(repo, digest string) (io.ReadCloser, error)
+	scanned map[string][]GrepMatch
+}
+
+// layer returns the matches in a layer. On a read error it returns the
+// matches found up to that point, which are not cached so that another tag
+// sharing the layer tries it again.
+func (s *layerSearch) layer(repo, digest string) ([]GrepMatch, error) {
+	if matches, ok := s.scanned[digest]; ok {
+		return matches, nil
+	}
+	body, err := s.open(repo, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	matches, err := grepLayer(body, digest, s.opts)
+	if err != nil {
+		return matches, err
+	}
+	s.scanned[digest] = matches
+	return matches, nil
+}
+
+// grepLayer searches every regular file of a layer stream
+func grepLayer(r io.Reader, digest string, opts GrepOptions) ([]GrepMatch, error) {
+	var matches []GrepMatch
+	err := layer.Walk(r, func(hdr *tar.Header, r io.Reader) error {
+		if hdr.Typeflag != tar.TypeReg || hdr.Size == 0 {
+			return nil
+		}
+		if opts.MaxFileSize > 0 && hdr.Size > opts.MaxFileSize {
+			return nil
+		}
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if layer.IsBinary(content) {
+			return nil
+		}
+		for _, m := range grepContent(content, opts) {
+			m.Layer = digest
+			m.Path = layer.CleanPath(hdr.Name)
+			matches = append(matches, m)
+		}
+		return nil
+	})
+	return matches, err
+}
+
+// grepContent returns the matching lines of content with their surrounding context
+func grepContent(content []byte, opts GrepOptions) []GrepMatch {
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	var matches []GrepMatch
+	for i, line := range lines {
+		if !opts.Pattern.MatchString(line) {
+			continue
+		}
+		m := GrepMatch{Line: i + 1, Text: strings.TrimRight(line, "\r")}
+		if opts.Context > 0 {
+			start := max(i-opts.Context, 0)
+			end := min(i+1+opts.Context, len(lines))
+			m.Before = append([]string(nil), lines[start:i]...)
+			m.After = append([]string(nil), lines[i+1:end]...)
+		}
+		matches = append(matches, m)
+	}
+	return matches
+}
+
+func printGrepMatch(m GrepMatch) {
+	success := color.New(color.FgGreen).SprintFunc()
+	for _, line := range m.Before {
+		fmt.Printf("    %s\n", line)
+	}
+	fmt.Printf("%s %s:%s %s %s:%d: %s\n", success("[+]"), m.Repo, m.Tag, m.Layer, m.Path, m.Line, m.Text)
+	for _, line := range m.After {
+		fmt.Printf("    %s\n", line)
+	}
+}
@@ -0,0 +1,159 @@
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// tarGz builds an in-memory gzip-compressed layer from name, body pairs
+func tarGz(t *testing.T, files ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for i := 0; i+1 < len(files); i += 2 {
+		hdr := &tar.Header{Name: files[i], Mode: 0644, Size: int64(len(files[i+1])), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(files[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// noise returns n bytes of text that does not compress, so a truncated
+// stream fails inside it
+func noise(n int) string {
+	var b strings.Builder
+	for sum := sha256.Sum256(nil); b.Len() < n; sum = sha256.Sum256(sum[:]) {
+		for _, c := range sum {
+			b.WriteByte('a' + c%26)
+		}
+	}
+	return b.String()
+}
+
+func TestLayerSearch(t *testing.T) {
+	blobs := map[string][]byte{
+		"sha256:app": tarGz(t,
+			"app/.env", "DEBUG=false\nAWS_SECRET_ACCESS_KEY=abc123\nPORT=8080\n",
+			"app/huge.txt", "password=ignored because too large\n"+strings.Repeat("x", 200),
+			"app/bin", "password=\x00binary",
+			"app/empty", "",
+		),
+		"sha256:none": tarGz(t, "etc/hostname", "box\n"),
+	}
+	truncated := tarGz(t,
+		"etc/config.yml", "db_password: hunter2\n",
+		"var/log/big.log", noise(256*1024),
+	)
+	blobs["sha256:truncated"] = truncated[:len(truncated)/2]
+
+	opened := make(map[string]int)
+	search := &layerSearch{
+		opts: GrepOptions{
+			Pattern:     regexp.MustCompile(`(?i)(secret|password)`),
+			MaxFileSize: 100,
+			Context:     1,
+		},
+		open: func(repo, digest string) (io.ReadCloser, error) {
+			opened[digest]++
+			return io.NopCloser(bytes.NewReader(blobs[digest])), nil
+		},
+		scanned: make(map[string][]GrepMatch),
+	}
+
+	tests := []struct {
+		name    string
+		digest  string
+		want    []GrepMatch
+		wantErr bool
+		opens   int // Times the blob has been opened after this search
+	}{
+		{
+			name:   "match with context",
+			digest: "sha256:app",
+			want: []GrepMatch{{
+				Layer:  "sha256:app",
+				Path:   "/app/.env",
+				Line:   2,
+				Text:   "AWS_SECRET_ACCESS_KEY=abc123",
+				Before: []string{"DEBUG=false"},
+				After:  []string{"PORT=8080"},
+			}},
+			opens: 1,
+		},
+		{
+			name:   "shared layer is served from the cache",
+			digest: "sha256:app",
+			want: []GrepMatch{{
+				Layer:  "sha256:app",
+				Path:   "/app/.env",
+				Line:   2,
+				Text:   "AWS_SECRET_ACCESS_KEY=abc123",
+				Before: []string{"DEBUG=false"},
+				After:  []string{"PORT=8080"},
+			}},
+			opens: 1,
+		},
+		{
+			name:   "no matches are cached too",
+			digest: "sha256:none",
+			opens:  1,
+		},
+		{
+			name:   "truncated layer keeps earlier matches",
+			digest: "sha256:truncated",
+			want: []GrepMatch{{
+				Layer: "sha256:truncated",
+				Path:  "/etc/config.yml",
+				Line:  1,
+				Text:  "db_password: hunter2",
+			}},
+			wantErr: true,
+			opens:   1,
+		},
+		{
+			name:   "truncated layer is read again",
+			digest: "sha256:truncated",
+			want: []GrepMatch{{
+				Layer: "sha256:truncated",
+				Path:  "/etc/config.yml",
+				Line:  1,
+				Text:  "db_password: hunter2",
+			}},
+			wantErr: true,
+			opens:   2,
+		},
+	}
+	// The cases share the search cache, so they run in order
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := search.layer("app", tt.digest)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("layer(%s) error = %v, want error %v", tt.digest, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("layer(%s) = %+v, want %+v", tt.digest, got, tt.want)
+			}
+			if opened[tt.digest] != tt.opens {
+				t.Errorf("%s opened %d times, want %d", tt.digest, opened[tt.digest], tt.opens)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/fatih/color"
+
+	"dockdiver/client"
+)
+
+// harborPageSize is the page size requested from the Harbor API, its maximum
+const harborPageSize = 100
+
+// HarborProject is a Harbor project with the repositories it holds
+type HarborProject struct {
+	ID           int                `json:"project_id"`
+	Name         string             `json:"name"`
+	OwnerName    string             `json:"owner_name,omitempty"`
+	RepoCount    int                `json:"repo_count"`
+	RegistryID   int                `json:"registry_id,omitempty"` // Set for proxy cache projects
+	CreationTime string             `json:"creation_time,omitempty"`
+	Metadata     map[string]string  `json:"metadata,omitempty"`
+	Repositories []HarborRepository `json:"repositories,omitempty"`
+}
+
+// Public reports whether anonymous users can pull from the project
+func (p HarborProject) Public() bool {
+	return p.Metadata["public"] == "true"
+}
+
+// HarborRepository is a repository as listed by the Harbor API; Name
+// includes the project, e.g. library/nginx
+type HarborRepository struct {
+	Name          string           `json:"name"`
+	Description   string           `json:"description,omitempty"`
+	ArtifactCount int              `json:"artifact_count"`
+	PullCount     int              `json:"pull_count"`
+	UpdateTime    string           `json:"update_time,omitempty"`
+	Artifacts     []HarborArtifact `json:"artifacts,omitempty"`
+}
+
+// HarborArtifact is an artifact of a repository with its tags and the
+// summary of its vulnerability scans
+type HarborArtifact struct {
+	Digest       string                `json:"digest"`
+	MediaType    string                `json:"media_type,omitempty"`
+	Size         int64                 `json:"size"`
+	PushTime     string                `json:"push_time,omitempty"`
+	PullTime     string                `json:"pull_time,omitempty"`
+	Tags         []HarborTag           `json:"tags,omitempty"`
+	ScanOverview map[string]HarborScan `json:"scan_overview,omitempty"` // By report MIME type
+}
+
+// HarborTag is a tag of an artifact
+type HarborTag struct {
+	Name string `json:"name"`
+}
+
+// HarborScan summarises a vulnerability scan of an artifact
+type HarborScan struct {
+	ScanStatus string `json:"scan_status"`
+	Severity   string `json:"severity"`
+	Summary    struct {
+		Total   int            `json:"total"`
+		Fixable int            `json:"fixable"`
+		Summary map[string]int `json:"summary"`
+	} `json:"summary"`
+}
+
+// HarborInventory is everything EnumerateHarbor could read
+type HarborInventory struct {
+	Projects []HarborProject `json:"projects"`
+}
+
+// Repositories returns the full names of all repositories found
+func (inv *HarborInventory) Repositories() []string {
+	var repos []string
+	for _, p := range inv.Projects {
+		for _, r := range p.Repositories {
+			repos = append(repos, r.Name)
+		}
+	}
+	return repos
+}
+
+// EnumerateHarbor lists projects, repositories and artifacts through the
+// Harbor REST API under /api/v2.0, which often shows public projects and
+// metadata such as pull counts and scan results when _catalog is
+// restricted. The API is found like the harbor catalog source's, at
+// opts.APIBase or the registry origin. Projects or repositories that cannot
+// be read are reported and skipped.
+func EnumerateHarbor(ep Endpoint, auth client.AuthConfig, cli Doer, opts CatalogOptions) (*HarborInventory, error) {
+	success := color.New(color.FgGreen).SprintFunc()
+	warning := color.New(color.FgYellow).SprintFunc()
+
+	base := opts.apiBase(ep)
+	projects, err := harborProjects(base, auth, cli)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Harbor projects: %w", err)
+	}
+	inv := &HarborInventory{Projects: projects}
+	fmt.Printf("%s Harbor lists %d projects\n", success("[+]"), len(inv.Projects))
+
+	for i := range inv.Projects {
+		p := &inv.Projects[i]
+		p.Repositories, err = harborRepositories(base, p.Name, auth, cli)
+		if err != nil {
+			fmt.Printf("%s Cannot list repositories of project %s: %v\n", warning("[!]"), p.Name, err)
+			continue
+		}
+
+		for j := range p.Repositories {
+			r := &p.Repositories[j]
+			// The repository name in the path excludes the project, with
+			// slashes escaped twice as Harbor requires
+			name := strings.TrimPrefix(r.Name, p.Name+"/")
+			path := "/api/v2.0/projects/" + url.PathEscape(p.Name) + "/repositories/" +
+				url.PathEscape(url.PathEscape(name)) + "/artifacts?with_tag=true&with_scan_overview=true"
+			err := harborList(base+path, auth, cli, func(dec *json.Decoder) (int, error) {
+				var page []HarborArtifact
+				err := dec.Decode(&page)
+				r.Artifacts = append(r.Artifacts, page...)
+				return len(page), err
+			})
+			if err != nil {
+				fmt.Printf("%s Cannot list artifacts of %s: %v\n", warning("[!]"), r.Name, err)
+			}
+		}
+	}
+	return inv, nil
+}
+
+// harborProjects lists the projects visible to auth
+func harborProjects(base string, auth client.AuthConfig, cli Doer) ([]HarborProject, error) {
+	var projects []HarborProject
+	err := harborList(base+"/api/v2.0/projects", auth, cli, func(dec *json.Decoder) (int, error) {
+		var page []HarborProject
+		err := dec.Decode(&page)
+		projects = append(projects, page...)
+		return len(page), err
+	})
+	return projects, err
+}
+
+// harborRepositories lists the repositories of a project
+func harborRepositories(base, project string, auth client.AuthConfig, cli Doer) ([]HarborRepository, error) {
+	var repos []HarborRepository
+	err := harborList(base+"/api/v2.0/projects/"+url.PathEscape(project)+"/repositories", auth, cli, func(dec *json.Decoder) (int, error) {
+		var page []HarborRepository
+		err := dec.Decode(&page)
+		repos = append(repos, page...)
+		return len(page), err
+	})
+	return repos, err
+}
+
+// harborList requests every page of a Harbor API list, passing each page
+// to decode, which returns how many items it held
+func harborList(listURL string, auth client.AuthConfig, cli Doer, decode func(*json.Decoder) (int, error)) error {
+	sep := "?"
+	if strings.Contains(listURL, "?") {
+		sep = "&"
+	}
+	header := http.Header{"Accept": {"application/json"}}
+	for page := 1; ; page++ {
+		pageURL := fmt.Sprintf("%s%spage=%d&page_size=%d", listURL, sep, page, harborPageSize)
+		resp, err := cli.Do("GET", pageURL, auth, header)
+		if err != nil {
+			return err
+		}
+		n, err := decode(json.NewDecoder(resp.Body))
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to decode %s: %v", pageURL, err)
+		}
+		if n < harborPageSize {
+			return nil
+		}
+	}
+}
+
+// harborCatalog lists repositories through the Harbor project API
+type harborCatalog struct {
+	opts CatalogOptions
+}
+
+func (harborCatalog) Name() string { return "harbor" }
+
+func (c harborCatalog) Repositories(ep Endpoint, auth client.AuthConfig, cli Doer) ([]string, error) {
+	base := c.opts.apiBase(ep)
+	projects, err := harborProjects(base, auth, cli)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Harbor projects: %w", err)
+	}
+	var names []string
+	for _, p := range projects {
+		repos, err := harborRepositories(base, p.Name, auth, cli)
+		if err != nil {
+			continue // Projects the identity cannot read are skipped
+		}
+		for _, r := range repos {
+			names = append(names, r.Name)
+		}
+	}
+	return uniqueSorted(names), nil
+}
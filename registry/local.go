@@ -0,0 +1,63 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"dockdiver/layer"
+)
+
+// DumpedImages returns the repositories that have a manifest.json inside
+// outputDir. The referrers stored next to a dumped image are not images.
+func DumpedImages(outputDir string) ([]string, error) {
+	var repos []string
+	err := filepath.WalkDir(outputDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == referrersDir {
+			if _, err := os.Stat(filepath.Join(filepath.Dir(path), "manifest.json")); err == nil {
+				return fs.SkipDir
+			}
+		}
+		if d.IsDir() || d.Name() != "manifest.json" {
+			return nil
+		}
+		repo, err := filepath.Rel(outputDir, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		repos = append(repos, filepath.ToSlash(repo))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %v", outputDir, err)
+	}
+	return repos, nil
+}
+
+// ImageLayers returns the layer files of a dumped repository, ordered from the
+// base layer up as listed in its manifest.json
+func ImageLayers(outputDir, repo string) ([]layer.Source, error) {
+	data, err := os.ReadFile(filepath.Join(outputDir, repo, "manifest.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest for %s: %v", repo, err)
+	}
+	var manifest imageManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest for %s: %v", repo, err)
+	}
+
+	var layers []layer.Source
+	for _, l := range manifest.Layers {
+		filename := layerFilename(outputDir, repo, l)
+		if _, err := os.Stat(filename); err != nil {
+			return nil, fmt.Errorf("layer %s of %s is missing: %v", l.Digest, repo, err)
+		}
+		layers = append(layers, layer.Source{Digest: l.Digest, File: filename})
+	}
+	return layers, nil
+}
@@ -0,0 +1,153 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"dockdiver/client"
+)
+
+// nexusCatalog lists repositories through the Nexus Repository REST API
+// under /service/rest/v1: the components of the docker repository the
+// endpoint serves, whose names are the image repositories. Anonymous access
+// to this API is often left on when _catalog needs a login.
+type nexusCatalog struct {
+	opts CatalogOptions
+}
+
+func (nexusCatalog) Name() string { return "nexus" }
+
+func (c nexusCatalog) Repositories(ep Endpoint, auth client.AuthConfig, cli Doer) ([]string, error) {
+	base := c.opts.apiBase(ep)
+	members, err := nexusServedRepositories(ep, base, auth, cli)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []string
+	var firstErr error
+	for _, name := range members {
+		found, err := nexusComponents(base, name, auth, cli)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		images = append(images, found...)
+	}
+	if len(images) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return uniqueSorted(images), nil
+}
+
+// nexusRepository is a repository as listed by /service/rest/v1/repositorySettings
+type nexusRepository struct {
+	Name   string `json:"name"`
+	Format string `json:"format"`
+	Docker *struct {
+		HTTPPort  int    `json:"httpPort"`
+		HTTPSPort int    `json:"httpsPort"`
+		Subdomain string `json:"subdomain"`
+	} `json:"docker"`
+	Group *struct {
+		MemberNames []string `json:"memberNames"`
+	} `json:"group"`
+}
+
+// nexusServedRepositories returns the docker repositories whose images the
+// endpoint serves: the one named by a /repository/<name> path, the one
+// whose connector port or subdomain the endpoint uses, or the only docker
+// repository. A group stands for its members, as components are listed
+// per hosted or proxy repository.
+func nexusServedRepositories(ep Endpoint, base string, auth client.AuthConfig, cli Doer) ([]string, error) {
+	header := http.Header{"Accept": {"application/json"}}
+	pathName, byPath := strings.CutPrefix(ep.BasePath, "/repository/")
+
+	// The settings name the connectors but usually need an administrator
+	var repositories []nexusRepository
+	resp, err := cli.Do("GET", base+"/service/rest/v1/repositorySettings", auth, header)
+	if err != nil {
+		resp, err = cli.Do("GET", base+"/service/rest/v1/repositories", auth, header)
+	}
+	if err != nil {
+		if byPath && pathName != "" {
+			return []string{pathName}, nil
+		}
+		return nil, fmt.Errorf("failed to list Nexus repositories: %w", err)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&repositories)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode Nexus repositories: %v", err)
+	}
+
+	subdomain, _, _ := strings.Cut(ep.Host, ".")
+	var docker []string
+	var served *nexusRepository
+	for i, r := range repositories {
+		if r.Format != "docker" {
+			continue
+		}
+		docker = append(docker, r.Name)
+		switch {
+		case byPath:
+			if r.Name == pathName {
+				served = &repositories[i]
+			}
+		case r.Docker != nil && (r.Docker.HTTPPort == ep.Port || r.Docker.HTTPSPort == ep.Port ||
+			(r.Docker.Subdomain != "" && strings.EqualFold(r.Docker.Subdomain, subdomain))):
+			served = &repositories[i]
+		}
+	}
+	switch {
+	case served != nil && served.Group != nil && len(served.Group.MemberNames) > 0:
+		return served.Group.MemberNames, nil
+	case served != nil:
+		return []string{served.Name}, nil
+	case byPath && pathName != "":
+		return []string{pathName}, nil
+	case len(docker) == 1:
+		return docker, nil
+	case len(docker) == 0:
+		return nil, fmt.Errorf("no docker repositories found in Nexus")
+	}
+	return nil, fmt.Errorf("cannot tell which of the Nexus docker repositories %s is served on %s; set -url to <nexus>/repository/<name>", strings.Join(docker, ", "), ep.Address())
+}
+
+// nexusComponents returns the names of the components of a Nexus
+// repository, following continuation tokens
+func nexusComponents(base, repository string, auth client.AuthConfig, cli Doer) ([]string, error) {
+	header := http.Header{"Accept": {"application/json"}}
+	var names []string
+	token := ""
+	for {
+		listURL := base + "/service/rest/v1/components?repository=" + url.QueryEscape(repository)
+		if token != "" {
+			listURL += "&continuationToken=" + url.QueryEscape(token)
+		}
+		resp, err := cli.Do("GET", listURL, auth, header)
+		if err != nil {
+			return names, fmt.Errorf("failed to list components of %s: %w", repository, err)
+		}
+		var page struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			ContinuationToken string `json:"continuationToken"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return names, fmt.Errorf("failed to decode components of %s: %v", repository, err)
+		}
+		for _, item := range page.Items {
+			names = append(names, item.Name)
+		}
+		if page.ContinuationToken == "" || page.ContinuationToken == token {
+			return names, nil
+		}
+		token = page.ContinuationToken
+	}
+}
@@ -0,0 +1,433 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+
+	"dockdiver/client"
+	"dockdiver/utils"
+)
+
+// Kinds of referrers
+const (
+	ReferrerSignature   = "signature"
+	ReferrerAttestation = "attestation"
+	ReferrerSBOM        = "sbom"
+	ReferrerArtifact    = "artifact" // Anything else attached to the image
+)
+
+// cosignTagSuffixes are the tags cosign pushes next to an image, named
+// sha256-<hex>.<suffix> after the digest of the image they belong to
+var cosignTagSuffixes = map[string]string{
+	"sig":  ReferrerSignature,
+	"att":  ReferrerAttestation,
+	"sbom": ReferrerSBOM,
+}
+
+// Referrer is an artifact attached to a dumped image, found through the
+// referrers API or the cosign tag schema
+type Referrer struct {
+	Kind         string        `json:"kind"`
+	Subject      string        `json:"subject"` // Digest of the image it refers to
+	Digest       string        `json:"digest"`
+	Tag          string        `json:"tag,omitempty"` // Set when found through the tag schema
+	ArtifactType string        `json:"artifact_type,omitempty"`
+	Annotations  []string      `json:"annotations,omitempty"`
+	Files        []string      `json:"files,omitempty"`
+	Signers      []string      `json:"signers,omitempty"` // Identities of Sigstore signing certificates
+	Attestations []Attestation `json:"attestations,omitempty"`
+	Error        string        `json:"error,omitempty"`
+}
+
+// Attestation summarises an in-toto statement: what it claims, who built the
+// image and the source and CI locations it mentions
+type Attestation struct {
+	PredicateType string   `json:"predicate_type"`
+	Builder       string   `json:"builder,omitempty"`
+	Locations     []string `json:"locations,omitempty"`
+}
+
+// referrersDir is the directory inside a dumped image the referrers are
+// stored in
+const referrersDir = "referrers"
+
+// maxAttestationLocations caps the URLs kept from a single predicate, which
+// for SLSA provenance may list every build material
+const maxAttestationLocations = 20
+
+// referrerManifest is the subset of a referrer manifest that dockdiver uses
+type referrerManifest struct {
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType"`
+	Config       descriptor        `json:"config"`
+	Layers       []layerDescriptor `json:"layers"`
+	Blobs        []layerDescriptor `json:"blobs"` // Artifact manifests from OCI 1.1 release candidates
+	Annotations  map[string]string `json:"annotations"`
+}
+
+// layerDescriptor is a descriptor with the annotations cosign stores
+// signatures and certificates in
+type layerDescriptor struct {
+	descriptor
+	Annotations map[string]string `json:"annotations"`
+}
+
+// DumpReferrers finds the signatures, attestations, SBOMs and other artifacts
+// attached to the image manifests with the given digests, first through the
+// referrers API and then through the cosign tag schema, and stores each
+// under <outputDir>/<repo>/referrers/ with a summary in referrers.json.
+// Failures are reported and do not stop the rest.
+func DumpReferrers(ep Endpoint, repo string, subjects []string, auth client.AuthConfig, outputDir string, cli *client.Client, opts DumpOptions) []Referrer {
+	success := color.New(color.FgGreen).SprintFunc()
+	warning := color.New(color.FgYellow).SprintFunc()
+
+	var referrers []Referrer
+	seen := make(map[string]bool)
+	for _, subject := range subjects {
+		found, err := listReferrers(ep, repo, subject, auth, cli)
+		if err != nil && !isNotFound(err) {
+			fmt.Printf("%s Referrers API unavailable for %s@%s: %v\n", warning("[!]"), repo, subject, err)
+		}
+		for _, r := range found {
+			if !seen[r.Digest] {
+				seen[r.Digest] = true
+				referrers = append(referrers, r)
+			}
+		}
+
+		for _, suffix := range []string{"sig", "att", "sbom"} {
+			tag := strings.Replace(subject, ":", "-", 1) + "." + suffix
+			digest, err := headManifest(ep, repo, tag, auth, cli)
+			if err != nil {
+				continue
+			}
+			if digest != "" {
+				if seen[digest] {
+					continue
+				}
+				seen[digest] = true
+			}
+			referrers = append(referrers, Referrer{Kind: cosignTagSuffixes[suffix], Subject: subject, Digest: digest, Tag: tag})
+		}
+	}
+	if len(referrers) == 0 {
+		return nil
+	}
+
+	fmt.Printf("%s Found %d referrers of %s\n", success("[+]"), len(referrers), repo)
+	for i := range referrers {
+		r := &referrers[i]
+		if err := dumpReferrer(ep, repo, r, auth, outputDir, cli, opts); err != nil {
+			r.Error = err.Error()
+			fmt.Printf("%s Error dumping %s %s: %v\n", warning("[!]"), r.Kind, referrerRef(*r), err)
+			continue
+		}
+		fmt.Printf("%s Saved %s %s (%d files)\n", success("[+]"), r.Kind, referrerRef(*r), len(r.Files))
+		for _, signer := range r.Signers {
+			fmt.Printf("    signer: %s\n", signer)
+		}
+		for _, a := range r.Attestations {
+			fmt.Printf("    predicate: %s\n", a.PredicateType)
+			if a.Builder != "" {
+				fmt.Printf("    builder: %s\n", a.Builder)
+			}
+			for _, location := range a.Locations {
+				fmt.Printf("    location: %s\n", location)
+			}
+		}
+	}
+
+	if data, err := json.MarshalIndent(referrers, "", "  "); err == nil {
+		if err := utils.StoreResponse(filepath.Join(outputDir, repo, "referrers.json"), data); err != nil {
+			fmt.Printf("%s Failed to save referrers of %s: %v\n", warning("[!]"), repo, err)
+		}
+	}
+	return referrers
+}
+
+// referrerRef names a referrer by tag when it has one, otherwise by digest
+func referrerRef(r Referrer) string {
+	if r.Tag != "" {
+		return r.Tag
+	}
+	return r.Digest
+}
+
+// listReferrers queries the referrers API for subject, following pagination
+func listReferrers(ep Endpoint, repo, subject string, auth client.AuthConfig, cli *client.Client) ([]Referrer, error) {
+	header := http.Header{"Accept": {"application/vnd.oci.image.index.v1+json"}}
+	var referrers []Referrer
+	nextURL := ep.V2("%s/referrers/%s", repo, subject)
+	for nextURL != "" {
+		resp, err := cli.Do("GET", nextURL, auth, header)
+		if err != nil {
+			return referrers, err
+		}
+		var index struct {
+			Manifests []struct {
+				Digest       string `json:"digest"`
+				ArtifactType string `json:"artifactType"`
+			} `json:"manifests"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&index)
+		link := resp.Header.Get("Link")
+		resp.Body.Close()
+		if err != nil {
+			return referrers, fmt.Errorf("failed to decode referrers of %s@%s: %v", repo, subject, err)
+		}
+		for _, m := range index.Manifests {
+			referrers = append(referrers, Referrer{
+				Kind:         referrerKind(m.ArtifactType),
+				Subject:      subject,
+				Digest:       m.Digest,
+				ArtifactType: m.ArtifactType,
+			})
+		}
+
+		nextURL = ""
+		if parts := strings.Split(link, ";"); len(parts) > 1 && strings.Contains(parts[1], `rel="next"`) {
+			if nextURL, err = ep.Resolve(strings.Trim(parts[0], "<> ")); err != nil {
+				return referrers, fmt.Errorf("failed to follow referrers pagination: %v", err)
+			}
+		}
+	}
+	return referrers, nil
+}
+
+// dumpReferrer stores the manifest and blobs of a referrer and fills in what
+// can be read from them
+func dumpReferrer(ep Endpoint, repo string, r *Referrer, auth client.AuthConfig, outputDir string, cli *client.Client, opts DumpOptions) error {
+	reference := r.Digest
+	if reference == "" {
+		reference = r.Tag
+	}
+	header := http.Header{"Accept": {strings.Join(manifestAccept, ", ")}}
+	resp, err := cli.Do("GET", ep.V2("%s/manifests/%s", repo, reference), auth, header)
+	if err != nil {
+		return fmt.Errorf("failed to fetch manifest: %w", err)
+	}
+	body, err := io.ReadAll(&timeoutReader{reader: resp.Body, timeout: 30 * time.Second})
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %v", err)
+	}
+	if r.Digest == "" {
+		r.Digest = fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+	}
+
+	var manifest referrerManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return fmt.Errorf("failed to parse manifest: %v", err)
+	}
+	if r.ArtifactType == "" {
+		r.ArtifactType = manifest.ArtifactType
+	}
+	if r.ArtifactType == "" && manifest.Config.MediaType != "application/vnd.oci.image.config.v1+json" {
+		r.ArtifactType = manifest.Config.MediaType
+	}
+	blobs := append(manifest.Layers, manifest.Blobs...)
+	if r.Kind == "" || r.Kind == ReferrerArtifact {
+		var layerType string
+		if len(blobs) > 0 {
+			layerType = blobs[0].MediaType
+		}
+		r.Kind = referrerKind(r.ArtifactType, manifest.Config.MediaType, layerType)
+	}
+	for k, v := range manifest.Annotations {
+		r.Annotations = append(r.Annotations, k+"="+v)
+	}
+	sort.Strings(r.Annotations)
+
+	dir := filepath.Join(outputDir, repo, referrersDir, r.Kind+"_"+strings.ReplaceAll(r.Digest, ":", "_"))
+	manifestFile := filepath.Join(dir, "manifest.json")
+	if err := utils.StoreResponse(manifestFile, body); err != nil {
+		return fmt.Errorf("failed to store manifest: %v", err)
+	}
+	r.Files = append(r.Files, manifestFile)
+
+	warning := color.New(color.FgYellow).SprintFunc()
+	if manifest.Config.Digest != "" && manifest.Config.Size > 2 { // Skip the empty {} config of artifacts
+		blobs = append([]layerDescriptor{{descriptor: manifest.Config}}, blobs...)
+	}
+	for _, blob := range blobs {
+		if blob.Digest == "" {
+			continue
+		}
+		ext := ".bin"
+		if strings.Contains(blob.MediaType, "json") {
+			ext = ".json"
+		}
+		file := filepath.Join(dir, "blob_"+strings.ReplaceAll(blob.Digest, ":", "_")+ext)
+		if err := getAndStoreBlobChunked(ep.V2("%s/blobs/%s", repo, blob.Digest), file, blob.descriptor, auth, cli, opts, warning); err != nil {
+			return fmt.Errorf("failed to download blob %s: %w", blob.Digest, err)
+		}
+		r.Files = append(r.Files, file)
+
+		if certPEM := blob.Annotations["dev.sigstore.cosign/certificate"]; certPEM != "" {
+			r.Signers = append(r.Signers, certificateIdentities(certPEM)...)
+		}
+		if blob.MediaType == "application/vnd.dsse.envelope.v1+json" || blob.MediaType == "application/vnd.in-toto+json" {
+			if a, err := readAttestation(file); err == nil {
+				r.Attestations = append(r.Attestations, *a)
+			}
+		}
+	}
+	return nil
+}
+
+// referrerKind classifies a referrer from its artifact, config or first
+// layer media type
+func referrerKind(mediaTypes ...string) string {
+	for _, mt := range mediaTypes {
+		switch {
+		case mt == "":
+		case strings.Contains(mt, "signature"), strings.Contains(mt, "simplesigning"), strings.HasPrefix(mt, "application/vnd.dev.cosign.artifact.sig"):
+			return ReferrerSignature
+		case strings.Contains(mt, "in-toto"), strings.Contains(mt, "dsse"), strings.Contains(mt, "provenance"), strings.Contains(mt, "attestation"):
+			return ReferrerAttestation
+		case strings.Contains(mt, "spdx"), strings.Contains(mt, "cyclonedx"), strings.Contains(mt, "syft"), strings.Contains(mt, "sbom"):
+			return ReferrerSBOM
+		}
+	}
+	return ReferrerArtifact
+}
+
+// fulcioIssuerOIDs hold the OIDC issuer a Sigstore certificate was issued
+// for, as a raw string (deprecated) and as a DER UTF8String
+var (
+	fulcioIssuerV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	fulcioIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// certificateIdentities returns the e-mail addresses and URIs a Sigstore
+// signing certificate was issued to, such as a CI workflow, with the issuer
+func certificateIdentities(certPEM string) []string {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil
+	}
+	issuer := ""
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(fulcioIssuerV2):
+			asn1.Unmarshal(ext.Value, &issuer)
+		case ext.Id.Equal(fulcioIssuerV1) && issuer == "":
+			issuer = string(ext.Value)
+		}
+	}
+	identities := append([]string(nil), cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	if issuer != "" {
+		for i := range identities {
+			identities[i] += " (issuer " + issuer + ")"
+		}
+	}
+	return identities
+}
+
+// readAttestation summarises an in-toto statement stored in file, either
+// bare or wrapped in a DSSE envelope
+func readAttestation(file string) (*Attestation, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var envelope struct {
+		PayloadType string `json:"payloadType"`
+		Payload     string `json:"payload"`
+	}
+	if json.Unmarshal(data, &envelope) == nil && envelope.Payload != "" {
+		if data, err = base64.StdEncoding.DecodeString(envelope.Payload); err != nil {
+			return nil, fmt.Errorf("invalid DSSE payload: %v", err)
+		}
+	}
+	var statement struct {
+		PredicateType string          `json:"predicateType"`
+		Predicate     json.RawMessage `json:"predicate"`
+	}
+	if err := json.Unmarshal(data, &statement); err != nil {
+		return nil, fmt.Errorf("invalid in-toto statement: %v", err)
+	}
+	if statement.PredicateType == "" {
+		return nil, errors.New("not an in-toto statement")
+	}
+
+	var predicate struct {
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"` // SLSA v0.2
+		RunDetails struct {
+			Builder struct {
+				ID string `json:"id"`
+			} `json:"builder"`
+		} `json:"runDetails"` // SLSA v1
+	}
+	json.Unmarshal(statement.Predicate, &predicate)
+	a := &Attestation{PredicateType: statement.PredicateType, Builder: predicate.Builder.ID}
+	if a.Builder == "" {
+		a.Builder = predicate.RunDetails.Builder.ID
+	}
+
+	var tree interface{}
+	json.Unmarshal(statement.Predicate, &tree)
+	for _, location := range collectLocations(tree, nil) {
+		if location != a.Builder {
+			a.Locations = append(a.Locations, location)
+		}
+	}
+	if len(a.Locations) > maxAttestationLocations {
+		a.Locations = a.Locations[:maxAttestationLocations]
+	}
+	return a, nil
+}
+
+// collectLocations returns the distinct URLs among the string values of a
+// decoded JSON tree, such as repository, workflow and material URIs
+func collectLocations(node interface{}, found []string) []string {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			found = collectLocations(v[k], found)
+		}
+	case []interface{}:
+		for _, item := range v {
+			found = collectLocations(item, found)
+		}
+	case string:
+		if strings.Contains(v, "://") && !strings.ContainsAny(v, " \n") {
+			for _, f := range found {
+				if f == v {
+					return found
+				}
+			}
+			found = append(found, v)
+		}
+	}
+	return found
+}
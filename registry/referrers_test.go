@@ -0,0 +1,276 @@
+package registry
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReferrerKind(t *testing.T) {
+	tests := []struct {
+		mediaTypes []string
+		want       string
+	}{
+		{[]string{"application/vnd.dev.cosign.simplesigning.v1+json"}, ReferrerSignature},
+		{[]string{"application/vnd.dev.cosign.artifact.sig.v1+json"}, ReferrerSignature},
+		{[]string{"application/vnd.cncf.notary.signature"}, ReferrerSignature},
+		{[]string{"application/vnd.dsse.envelope.v1+json"}, ReferrerAttestation},
+		{[]string{"application/vnd.in-toto+json"}, ReferrerAttestation},
+		{[]string{"https://slsa.dev/provenance/v1"}, ReferrerAttestation},
+		{[]string{"application/spdx+json"}, ReferrerSBOM},
+		{[]string{"application/vnd.cyclonedx+json"}, ReferrerSBOM},
+		{[]string{"application/vnd.syft+json"}, ReferrerSBOM},
+		// An empty artifact type and config defer to the layer
+		{[]string{"", "application/vnd.oci.empty.v1+json", "application/spdx+json"}, ReferrerSBOM},
+		{[]string{"application/vnd.example.helm.chart"}, ReferrerArtifact},
+		{nil, ReferrerArtifact},
+	}
+	for _, tt := range tests {
+		if got := referrerKind(tt.mediaTypes...); got != tt.want {
+			t.Errorf("referrerKind(%q) = %q, want %q", tt.mediaTypes, got, tt.want)
+		}
+	}
+}
+
+// signingCertificate returns a PEM certificate like the ones Fulcio issues,
+// with the issuer in the given extensions
+func signingCertificate(t *testing.T, email, uri string, extensions ...pkix.Extension) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		NotBefore:       time.Now(),
+		NotAfter:        time.Now().Add(10 * time.Minute),
+		ExtraExtensions: extensions,
+	}
+	if email != "" {
+		template.EmailAddresses = []string{email}
+	}
+	if uri != "" {
+		u, err := url.Parse(uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.URIs = []*url.URL{u}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestCertificateIdentities(t *testing.T) {
+	const (
+		workflow = "https://github.com/acme/app/.github/workflows/release.yml@refs/tags/v1.0.0"
+		github   = "https://token.actions.githubusercontent.com"
+		google   = "https://accounts.google.com"
+	)
+	issuerV2, err := asn1.MarshalWithParams(github, "utf8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cert string
+		want []string
+	}{
+		{
+			name: "V1 issuer",
+			cert: signingCertificate(t, "dev@acme.example", "", pkix.Extension{Id: fulcioIssuerV1, Value: []byte(google)}),
+			want: []string{"dev@acme.example (issuer " + google + ")"},
+		},
+		{
+			name: "V2 issuer",
+			cert: signingCertificate(t, "", workflow, pkix.Extension{Id: fulcioIssuerV2, Value: issuerV2}),
+			want: []string{workflow + " (issuer " + github + ")"},
+		},
+		{
+			name: "V2 issuer wins over V1",
+			cert: signingCertificate(t, "", workflow,
+				pkix.Extension{Id: fulcioIssuerV1, Value: []byte("https://stale.example")},
+				pkix.Extension{Id: fulcioIssuerV2, Value: issuerV2},
+			),
+			want: []string{workflow + " (issuer " + github + ")"},
+		},
+		{
+			name: "no issuer",
+			cert: signingCertificate(t, "dev@acme.example", workflow),
+			want: []string{"dev@acme.example", workflow},
+		},
+		{
+			name: "not a certificate",
+			cert: "-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n",
+		},
+		{
+			name: "not PEM",
+			cert: "MEUCIQ...",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := certificateIdentities(tt.cert); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("certificateIdentities() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+const slsaV02Statement = `{
+  "_type": "https://in-toto.io/Statement/v0.1",
+  "predicateType": "https://slsa.dev/provenance/v0.2",
+  "subject": [{"name": "registry.example.com/app", "digest": {"sha256": "abc"}}],
+  "predicate": {
+    "builder": {"id": "https://github.com/actions/runner"},
+    "buildType": "https://mobyproject.org/buildkit@v1",
+    "invocation": {
+      "configSource": {"uri": "git+https://github.com/acme/app@refs/heads/main", "entryPoint": "Dockerfile"}
+    },
+    "materials": [
+      {"uri": "git+https://github.com/acme/app@refs/heads/main"},
+      {"uri": "pkg:docker/golang@1.22?platform=linux%2Famd64"}
+    ]
+  }
+}`
+
+const slsaV1Statement = `{
+  "_type": "https://in-toto.io/Statement/v1",
+  "predicateType": "https://slsa.dev/provenance/v1",
+  "predicate": {
+    "buildDefinition": {
+      "buildType": "https://actions.github.io/buildtypes/workflow/v1",
+      "externalParameters": {
+        "workflow": {"ref": "refs/tags/v1.0.0", "repository": "https://github.com/acme/app", "path": ".github/workflows/release.yml"}
+      }
+    },
+    "runDetails": {
+      "builder": {"id": "https://github.com/acme/app/.github/workflows/release.yml@refs/tags/v1.0.0"},
+      "metadata": {"invocationId": "https://github.com/acme/app/actions/runs/42/attempts/1"}
+    }
+  }
+}`
+
+func TestReadAttestation(t *testing.T) {
+	dsse := func(statement string) string {
+		return fmt.Sprintf(`{"payloadType": "application/vnd.in-toto+json", "payload": %q, "signatures": [{"sig": "MEUC"}]}`,
+			base64.StdEncoding.EncodeToString([]byte(statement)))
+	}
+	var materials []string
+	for i := 0; i < 25; i++ {
+		materials = append(materials, fmt.Sprintf(`{"uri": "https://proxy.golang.org/mod%d"}`, i))
+	}
+	manyMaterials := `{"predicateType": "https://slsa.dev/provenance/v0.2", "predicate": {"materials": [` + strings.Join(materials, ",") + `]}}`
+
+	tests := []struct {
+		name    string
+		data    string
+		want    *Attestation
+		wantErr bool
+	}{
+		{
+			name: "DSSE-wrapped SLSA v0.2",
+			data: dsse(slsaV02Statement),
+			want: &Attestation{
+				PredicateType: "https://slsa.dev/provenance/v0.2",
+				Builder:       "https://github.com/actions/runner",
+				Locations:     []string{"https://mobyproject.org/buildkit@v1", "git+https://github.com/acme/app@refs/heads/main"},
+			},
+		},
+		{
+			name: "bare SLSA v1",
+			data: slsaV1Statement,
+			want: &Attestation{
+				PredicateType: "https://slsa.dev/provenance/v1",
+				Builder:       "https://github.com/acme/app/.github/workflows/release.yml@refs/tags/v1.0.0",
+				Locations: []string{
+					"https://actions.github.io/buildtypes/workflow/v1",
+					"https://github.com/acme/app",
+					"https://github.com/acme/app/actions/runs/42/attempts/1",
+				},
+			},
+		},
+		{
+			name: "DSSE-wrapped SLSA v1",
+			data: dsse(slsaV1Statement),
+			want: &Attestation{
+				PredicateType: "https://slsa.dev/provenance/v1",
+				Builder:       "https://github.com/acme/app/.github/workflows/release.yml@refs/tags/v1.0.0",
+				Locations: []string{
+					"https://actions.github.io/buildtypes/workflow/v1",
+					"https://github.com/acme/app",
+					"https://github.com/acme/app/actions/runs/42/attempts/1",
+				},
+			},
+		},
+		{
+			name: "locations are capped",
+			data: manyMaterials,
+			want: &Attestation{
+				PredicateType: "https://slsa.dev/provenance/v0.2",
+				Locations: func() []string {
+					var want []string
+					for i := 0; i < maxAttestationLocations; i++ {
+						want = append(want, fmt.Sprintf("https://proxy.golang.org/mod%d", i))
+					}
+					return want
+				}(),
+			},
+		},
+		{name: "invalid DSSE payload", data: `{"payload": "%%%"}`, wantErr: true},
+		{name: "not a statement", data: `{"mediaType": "application/vnd.oci.image.manifest.v1+json"}`, wantErr: true},
+		{name: "not JSON", data: `SPDXVersion: SPDX-2.3`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "attestation.json")
+			if err := os.WriteFile(file, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readAttestation(file)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("readAttestation() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readAttestation(): %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readAttestation() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollectLocations(t *testing.T) {
+	tree := map[string]interface{}{
+		"b": []interface{}{"https://b.example/1", "not a url", map[string]interface{}{"uri": "oci://registry/app"}},
+		"a": "https://a.example",
+		"c": "https://b.example/1",
+		"d": "https://with space.example/x y",
+		"e": 42.0,
+	}
+	want := []string{"https://a.example", "https://b.example/1", "oci://registry/app"}
+	if got := collectLocations(tree, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("collectLocations() = %q, want %q", got, want)
+	}
+}
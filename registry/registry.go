@@ -4,6 +4,7 @@ import (
         "crypto/sha256"
         "encoding/hex"
         "encoding/json"
+        "errors"
         "fmt"
         "io"
         "os"
@@ -46,16 +47,17 @@ func (pr *progressReader) Read(p []byte) (int, error) {
         return n, err
 }
 
-func ListRepositories(url string, port int, auth client.AuthConfig, cli *client.Client) ([]string, error) {
+// listCatalog pages through /v2/_catalog
+func listCatalog(ep Endpoint, auth client.AuthConfig, cli Doer) ([]string, error) {
         var allRepos []string
         const pageSize = 100 // Limit to 100 repositories per request
-        nextURL := fmt.Sprintf("%s:%d/v2/_catalog?n=%d", url, port, pageSize)
+        nextURL := ep.V2("_catalog?n=%d", pageSize)
 
         for nextURL != "" {
                 fmt.Printf("%s Fetching catalog: %s\n", color.New(color.FgYellow).SprintFunc()("[!]"), nextURL)
-                resp, err := cli.MakeRequest(nextURL, auth)
+                resp, err := cli.Do("GET", nextURL, auth, nil)
                 if err != nil {
-                        return nil, fmt.Errorf("failed to fetch catalog: %v", err)
+                        return nil, fmt.Errorf("failed to fetch catalog: %w", err)
                 }
 
                 var catalog struct {
@@ -76,30 +78,62 @@ func ListRepositories(url string, port int, auth client.AuthConfig, cli *client.
                                 linkURL := strings.Trim(parts[0], "<> ")
                                 if linkURL != "" {
                                         // Ensure absolute URL
-                                        if !strings.HasPrefix(linkURL, "http") {
-                                                linkURL = fmt.Sprintf("%s:%d%s", url, port, linkURL)
+                                        resolved, err := ep.Resolve(linkURL)
+                                        if err != nil {
+                                                resp.Body.Close()
+                                                return nil, fmt.Errorf("failed to follow catalog pagination: %v", err)
                                         }
-                                        nextURL = linkURL
+                                        nextURL = resolved
                                 }
                         }
                 }
                 resp.Body.Close()
         }
 
-        if len(allRepos) == 0 {
-                return nil, fmt.Errorf("no repositories found")
-        }
         return allRepos, nil
 }
 
-func DumpAllRepositories(url string, port int, auth client.AuthConfig, outputDir string, cli *client.Client) error {
-        repos, err := ListRepositories(url, port, auth, cli)
+// DumpOptions controls how many downloads run in parallel
+type DumpOptions struct {
+        Concurrency     int             // Blobs fetched in parallel for a single image
+        RepoConcurrency int             // Repositories dumped in parallel by DumpAllRepositories
+        Chunks          int             // Parallel Range requests per blob, 1 disables chunking
+        ChunkMinSize    int64           // Blobs smaller than this are fetched as a single stream
+        TagCandidates   []string        // Tags probed by HEAD when tags/list is forbidden or empty
+        Referrers       bool            // Also dump signatures, attestations and SBOMs attached to images
+        Catalogs        []CatalogSource // Sources DumpAllRepositories lists with, _catalog when empty
+        // OnToken, when set, is called with the credentials obtained by
+        // answering a Bearer challenge, before the dump is retried
+        OnToken func(repo string, auth client.AuthConfig)
+}
+
+// workers returns n, or 1 when n is not a usable worker count
+func workers(n int) int {
+        if n < 1 {
+                return 1
+        }
+        return n
+}
+
+func DumpAllRepositories(ep Endpoint, auth client.AuthConfig, outputDir string, cli *client.Client, opts DumpOptions) error {
+        repos, err := ListRepositories(ep, auth, cli, opts.Catalogs)
         if err != nil {
                 return err
         }
+        DumpRepositories(ep, repos, auth, outputDir, cli, opts)
+        return nil
+}
+
+// DumpRepositories dumps repos in parallel. Missing or denied repositories
+// are skipped and rate-limited ones are retried once at the end.
+func DumpRepositories(ep Endpoint, repos []string, auth client.AuthConfig, outputDir string, cli *client.Client, opts DumpOptions) {
+        errorColor := color.New(color.FgRed).SprintFunc()
+        warning := color.New(color.FgYellow).SprintFunc()
 
         var wg sync.WaitGroup
-        semaphore := make(chan struct{}, 5)
+        var mu sync.Mutex
+        var rateLimited []string
+        semaphore := make(chan struct{}, workers(opts.RepoConcurrency))
 
         for _, repo := range repos {
                 wg.Add(1)
@@ -107,101 +141,187 @@ func DumpAllRepositories(url string, port int, auth client.AuthConfig, outputDir
                 go func(r string) {
                         defer wg.Done()
                         defer func() { <-semaphore }()
-                        if err := DumpRepository(url, port, r, auth, outputDir, cli); err != nil {
-                                fmt.Printf("%s Error dumping %s: %v\n", color.New(color.FgRed).SprintFunc()("[-]"), r, err)
+                        err := DumpRepositoryReauth(ep, r, auth, outputDir, cli, opts)
+                        switch {
+                        case err == nil:
+                        case errors.Is(err, client.ErrTooManyRequests):
+                                fmt.Printf("%s Rate limited while dumping %s, will retry after the other repositories: %v\n", warning("[!]"), r, err)
+                                mu.Lock()
+                                rateLimited = append(rateLimited, r)
+                                mu.Unlock()
+                        case errors.Is(err, client.ErrNameUnknown), errors.Is(err, client.ErrManifestUnknown), errors.Is(err, client.ErrDenied):
+                                fmt.Printf("%s Skipping %s: %v\n", warning("[!]"), r, err)
+                        default:
+                                fmt.Printf("%s Error dumping %s: %v\n", errorColor("[-]"), r, err)
                         }
                 }(repo)
         }
-
         wg.Wait()
-        return nil
+
+        // Repositories that hit the registry rate limit get one more sequential pass
+        for _, r := range rateLimited {
+                if err := DumpRepositoryReauth(ep, r, auth, outputDir, cli, opts); err != nil {
+                        fmt.Printf("%s Error dumping %s: %v\n", errorColor("[-]"), r, err)
+                }
+        }
 }
 
-func DumpRepository(url string, port int, repo string, auth client.AuthConfig, outputDir string, cli *client.Client) error {
-        success := color.New(color.FgGreen).SprintFunc()
-        errorColor := color.New(color.FgRed).SprintFunc()
-        warning := color.New(color.FgYellow).SprintFunc()
+// DumpRepositoryReauth dumps a repository, answering a Bearer challenge with
+// a freshly requested token and trying once more
+func DumpRepositoryReauth(ep Endpoint, repo string, auth client.AuthConfig, outputDir string, cli *client.Client, opts DumpOptions) error {
+        err := DumpRepository(ep, repo, auth, outputDir, cli, opts)
+        if !errors.Is(err, client.ErrUnauthorized) {
+                return err
+        }
+        tokenAuth, authErr := cli.Reauthenticate(err, auth)
+        if authErr != nil {
+                return fmt.Errorf("%w (re-authentication failed: %v)", err, authErr)
+        }
+        fmt.Printf("%s Obtained a token for %s, retrying\n", color.New(color.FgYellow).SprintFunc()("[!]"), repo)
+        if opts.OnToken != nil {
+                opts.OnToken(repo, tokenAuth)
+        }
+        return DumpRepository(ep, repo, tokenAuth, outputDir, cli, opts)
+}
 
-        if err := utils.CreateDir(outputDir); err != nil {
-                return fmt.Errorf("failed to create output directory: %v", err)
+// descriptor references a blob from a manifest
+type descriptor struct {
+        Digest    string `json:"digest"`
+        MediaType string `json:"mediaType"`
+        Size      int64  `json:"size"`
+}
+
+// imageManifest is the subset of a v2 image manifest that dockdiver uses
+type imageManifest struct {
+        Config descriptor   `json:"config"`
+        Layers []descriptor `json:"layers"`
+}
+
+// layerFilename returns where a layer blob of repo is stored inside outputDir
+func layerFilename(outputDir, repo string, layer descriptor) string {
+        ext := ".tar.gz"
+        if layer.MediaType != "application/vnd.docker.image.rootfs.diff.tar.gzip" {
+                ext = ".bin"
+        }
+        safeDigest := strings.ReplaceAll(layer.Digest, ":", "_")
+        return filepath.Join(outputDir, repo, fmt.Sprintf("layer_%s%s", safeDigest, ext))
+}
+
+// errNoTags is returned by fetchTags for a repository without tags
+var errNoTags = errors.New("no tags found")
+
+// fetchTags returns the tags of a repository, failing if there are none
+func fetchTags(ep Endpoint, repo string, auth client.AuthConfig, cli *client.Client) ([]string, error) {
+        tagsURL := ep.V2("%s/tags/list", repo)
+        fmt.Printf("%s Fetching tags for %s: %s\n", color.New(color.FgYellow).SprintFunc()("[!]"), repo, tagsURL)
+        tags, err := listTags(ep, repo, auth, cli)
+        if err != nil {
+                return nil, fmt.Errorf("failed to fetch tags for %s: %w", repo, err)
+        }
+        if len(tags) == 0 {
+                return nil, fmt.Errorf("%w for %s", errNoTags, repo)
         }
+        return tags, nil
+}
 
-        tagsURL := fmt.Sprintf("%s:%d/v2/%s/tags/list", url, port, repo)
-        fmt.Printf("%s Fetching tags for %s: %s\n", warning("[!]"), repo, tagsURL)
-        resp, err := cli.MakeRequest(tagsURL, auth)
+// fetchManifest retrieves and parses the manifest of repo:reference without storing it
+func fetchManifest(ep Endpoint, repo, reference string, auth client.AuthConfig, cli *client.Client) (*imageManifest, []byte, error) {
+        manifestURL := ep.V2("%s/manifests/%s", repo, reference)
+        resp, err := cli.MakeRequest(manifestURL, auth)
         if err != nil {
-                return fmt.Errorf("failed to fetch tags for %s: %v", repo, err)
+                return nil, nil, fmt.Errorf("failed to fetch manifest for %s:%s: %w", repo, reference, err)
         }
         defer resp.Body.Close()
 
-        var tags struct {
-                Tags []string `json:"tags"`
+        body, err := io.ReadAll(&timeoutReader{reader: resp.Body, timeout: 30 * time.Second})
+        if err != nil {
+                return nil, nil, fmt.Errorf("failed to read manifest for %s:%s: %v", repo, reference, err)
         }
-        if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
-                return fmt.Errorf("failed to decode tags for %s: %v", repo, err)
+        var manifest imageManifest
+        if err := json.Unmarshal(body, &manifest); err != nil {
+                return nil, nil, fmt.Errorf("failed to parse manifest for %s:%s: %v", repo, reference, err)
         }
-        if len(tags.Tags) == 0 {
-                return fmt.Errorf("no tags found for %s", repo)
+        return &manifest, body, nil
+}
+
+func DumpRepository(ep Endpoint, repo string, auth client.AuthConfig, outputDir string, cli *client.Client, opts DumpOptions) error {
+        success := color.New(color.FgGreen).SprintFunc()
+        errorColor := color.New(color.FgRed).SprintFunc()
+        warning := color.New(color.FgYellow).SprintFunc()
+
+        if err := utils.CreateDir(outputDir); err != nil {
+                return fmt.Errorf("failed to create output directory: %v", err)
+        }
+
+        tags, err := repositoryTags(ep, repo, auth, cli, opts)
+        if err != nil {
+                return err
         }
 
-        tag := tags.Tags[0]
+        tag := tags[0]
         fmt.Printf("%s Selected tag: %s\n", warning("[!]"), tag)
-        manifestURL := fmt.Sprintf("%s:%d/v2/%s/manifests/%s", url, port, repo, tag)
+        manifestURL := ep.V2("%s/manifests/%s", repo, tag)
         manifestFile := filepath.Join(outputDir, repo, "manifest.json")
         fmt.Printf("%s Fetching manifest: %s\n", warning("[!]"), manifestURL)
         manifestBody, err := getAndStoreResponse(manifestURL, manifestFile, auth, cli)
         if err != nil {
-                return fmt.Errorf("failed to fetch manifest for %s:%s: %v", repo, tag, err)
+                return fmt.Errorf("failed to fetch manifest for %s:%s: %w", repo, tag, err)
         }
 
-        var manifest struct {
-                Config struct {
-                        Digest    string `json:"digest"`
-                        MediaType string `json:"mediaType"`
-                } `json:"config"`
-                Layers []struct {
-                        Digest    string `json:"digest"`
-                        MediaType string `json:"mediaType"`
-                } `json:"layers"`
-        }
+        var manifest imageManifest
         if err := json.Unmarshal([]byte(manifestBody), &manifest); err != nil {
                 return fmt.Errorf("failed to parse manifest for %s:%s: %v", repo, tag, err)
         }
 
-        // Dump config blob
+        // Collect the config and layer blobs so they can be fetched in parallel
+        type blobJob struct {
+                label string
+                blob  descriptor
+                file  string
+        }
+        var jobs []blobJob
         if manifest.Config.Digest != "" {
-                blobURL := fmt.Sprintf("%s:%d/v2/%s/blobs/%s", url, port, repo, manifest.Config.Digest)
                 ext := ".json"
                 if manifest.Config.MediaType != "application/vnd.docker.container.image.v1+json" {
                         ext = ".bin"
                 }
                 safeDigest := strings.ReplaceAll(manifest.Config.Digest, ":", "_")
                 blobFile := filepath.Join(outputDir, repo, fmt.Sprintf("config_%s%s", safeDigest, ext))
-                fmt.Printf("%s Fetching config blob: %s\n", warning("[!]"), blobURL)
-                if _, err := getAndStoreBlob(blobURL, blobFile, manifest.Config.Digest, auth, cli, warning); err != nil {
-                        fmt.Printf("%s Error downloading config %s: %v\n", errorColor("[-]"), manifest.Config.Digest, err)
-                } else {
-                        fmt.Printf("%s Config %s downloaded and verified\n", success("[+]"), manifest.Config.Digest)
-                }
+                jobs = append(jobs, blobJob{label: "Config", blob: manifest.Config, file: blobFile})
         }
-
-        // Dump layer blobs
         for i, layer := range manifest.Layers {
                 if layer.Digest != "" {
-                        blobURL := fmt.Sprintf("%s:%d/v2/%s/blobs/%s", url, port, repo, layer.Digest)
-                        ext := ".tar.gz"
-                        if layer.MediaType != "application/vnd.docker.image.rootfs.diff.tar.gzip" {
-                                ext = ".bin"
-                        }
-                        safeDigest := strings.ReplaceAll(layer.Digest, ":", "_")
-                        blobFile := filepath.Join(outputDir, repo, fmt.Sprintf("layer_%s%s", safeDigest, ext))
-                        fmt.Printf("%s Fetching layer %d blob: %s\n", warning("[!]"), i+1, blobURL)
-                        if _, err := getAndStoreBlob(blobURL, blobFile, layer.Digest, auth, cli, warning); err != nil {
-                                fmt.Printf("%s Error downloading layer %s: %v\n", errorColor("[-]"), layer.Digest, err)
+                        jobs = append(jobs, blobJob{label: fmt.Sprintf("Layer %d", i+1), blob: layer, file: layerFilename(outputDir, repo, layer)})
+                }
+        }
+
+        var wg sync.WaitGroup
+        semaphore := make(chan struct{}, workers(opts.Concurrency))
+        for _, job := range jobs {
+                wg.Add(1)
+                semaphore <- struct{}{}
+                go func(j blobJob) {
+                        defer wg.Done()
+                        defer func() { <-semaphore }()
+                        blobURL := ep.V2("%s/blobs/%s", repo, j.blob.Digest)
+                        fmt.Printf("%s Fetching %s blob: %s\n", warning("[!]"), strings.ToLower(j.label), blobURL)
+                        if err := getAndStoreBlobChunked(blobURL, j.file, j.blob, auth, cli, opts, warning); err != nil {
+                                fmt.Printf("%s Error downloading %s %s: %v\n", errorColor("[-]"), strings.ToLower(j.label), j.blob.Digest, err)
                         } else {
-                                fmt.Printf("%s Layer %s downloaded and verified\n", success("[+]"), layer.Digest)
+                                fmt.Printf("%s %s %s downloaded and verified\n", success("[+]"), j.label, j.blob.Digest)
                         }
+                }(job)
+        }
+        wg.Wait()
+
+        if opts.Referrers {
+                // Signatures usually refer to the digest the tag points to,
+                // which differs from the dumped manifest for multi-platform images
+                subjects := []string{fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(manifestBody)))}
+                if digest, err := headManifest(ep, repo, tag, auth, cli); err == nil && digest != "" && digest != subjects[0] {
+                        subjects = append(subjects, digest)
                 }
+                DumpReferrers(ep, repo, subjects, auth, outputDir, cli, opts)
         }
 
         fmt.Printf("%s Dumped %s successfully\n", success("[+]"), repo)
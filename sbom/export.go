@@ -0,0 +1,294 @@
+package sbom
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// purl builds the package URL used to identify a package in both SBOM formats
+func purl(pkg Package, osID, osVersion string) string {
+	var namespace string
+	name := pkg.Name
+	qualifiers := url.Values{}
+
+	switch pkg.Type {
+	case TypeDeb, TypeApk, TypeRpm:
+		namespace = osID
+		if pkg.Arch != "" {
+			qualifiers.Set("arch", pkg.Arch)
+		}
+		if osID != "" && osVersion != "" {
+			qualifiers.Set("distro", osID+"-"+osVersion)
+		}
+	case TypePyPI:
+		name = strings.ToLower(strings.NewReplacer("_", "-", ".", "-").Replace(name))
+	case TypeNpm:
+		if scope, rest, ok := strings.Cut(name, "/"); ok && strings.HasPrefix(scope, "@") {
+			namespace, name = scope, rest
+		}
+	case TypeMaven:
+		if group, artifact, ok := strings.Cut(name, ":"); ok {
+			namespace, name = group, artifact
+		}
+	case TypeGolang:
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			namespace, name = name[:i], name[i+1:]
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("pkg:" + pkg.Type + "/")
+	if namespace != "" {
+		for _, segment := range strings.Split(namespace, "/") {
+			b.WriteString(purlEscape(segment) + "/")
+		}
+	}
+	b.WriteString(purlEscape(name) + "@" + purlEscape(pkg.Version))
+	if len(qualifiers) > 0 {
+		b.WriteString("?" + qualifiers.Encode())
+	}
+	return b.String()
+}
+
+// purlEscape percent-encodes a purl component, including the '@' of npm scopes
+func purlEscape(s string) string {
+	return strings.ReplaceAll(url.PathEscape(s), "@", "%40")
+}
+
+// newUUID returns a random RFC 4122 version 4 UUID
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate UUID: %v", err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+var spdxIDInvalid = regexp.MustCompile(`[^A-Za-z0-9.-]`)
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+	ExtractedLicenses []spdxLicenseInfo  `json:"hasExtractedLicensingInfos,omitempty"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	SourceInfo       string            `json:"sourceInfo,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+// spdxLicenseInfo declares a LicenseRef used by the document
+type spdxLicenseInfo struct {
+	LicenseID     string `json:"licenseId"`
+	ExtractedText string `json:"extractedText"`
+	Name          string `json:"name"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// ToSPDX converts an inventory into an SPDX 2.3 JSON document
+func ToSPDX(inv *Inventory) (interface{}, error) {
+	id, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              inv.Image,
+		DocumentNamespace: "https://dockdiver/spdx/" + url.PathEscape(inv.Image) + "-" + id,
+		CreationInfo: spdxCreationInfo{
+			Created:  time.Now().UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: dockdiver"},
+		},
+		Packages: []spdxPackage{{
+			Name:             inv.Image,
+			SPDXID:           "SPDXRef-Image",
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+		}},
+		Relationships: []spdxRelationship{{
+			SPDXElementID:      "SPDXRef-DOCUMENT",
+			RelationshipType:   "DESCRIBES",
+			RelatedSPDXElement: "SPDXRef-Image",
+		}},
+	}
+
+	refs := make(map[string]string)
+	for i, pkg := range inv.Packages {
+		spdxID := fmt.Sprintf("SPDXRef-Package-%s-%d", spdxIDInvalid.ReplaceAllString(pkg.Name, "-"), i)
+		declared := "NOASSERTION"
+		if pkg.License != "" {
+			declared = doc.licenseRef(pkg.License, refs)
+		}
+		doc.Packages = append(doc.Packages, spdxPackage{
+			Name:             pkg.Name,
+			SPDXID:           spdxID,
+			VersionInfo:      pkg.Version,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  declared,
+			SourceInfo:       "found in " + pkg.Location,
+			ExternalRefs: []spdxExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  pkg.PURL,
+			}},
+		})
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			SPDXElementID:      "SPDXRef-Image",
+			RelationshipType:   "CONTAINS",
+			RelatedSPDXElement: spdxID,
+		})
+	}
+	return doc, nil
+}
+
+// licenseRef returns license as an SPDX expression or, for free-form license
+// strings, as a LicenseRef declared once in hasExtractedLicensingInfos. refs
+// maps the declared LicenseRefs to their text.
+func (doc *spdxDocument) licenseRef(license string, refs map[string]string) string {
+	license = strings.TrimSpace(license)
+	if license == "" {
+		return "NOASSERTION"
+	}
+	if expr, ok := spdxExpression(license); ok {
+		return expr
+	}
+	base := "LicenseRef-" + spdxIDInvalid.ReplaceAllString(license, "-")
+	ref := base
+	// Different texts may sanitize to the same identifier
+	for n := 2; ; n++ {
+		text, taken := refs[ref]
+		if !taken {
+			break
+		}
+		if text == license {
+			return ref
+		}
+		ref = fmt.Sprintf("%s-%d", base, n)
+	}
+	refs[ref] = license
+	doc.ExtractedLicenses = append(doc.ExtractedLicenses, spdxLicenseInfo{
+		LicenseID:     ref,
+		ExtractedText: license,
+		Name:          license,
+	})
+	return ref
+}
+
+type cdxDocument struct {
+	BOMFormat    string         `json:"bomFormat"`
+	SpecVersion  string         `json:"specVersion"`
+	SerialNumber string         `json:"serialNumber"`
+	Version      int            `json:"version"`
+	Metadata     cdxMetadata    `json:"metadata"`
+	Components   []cdxComponent `json:"components"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     []cdxTool    `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTool struct {
+	Name string `json:"name"`
+}
+
+type cdxComponent struct {
+	BOMRef     string        `json:"bom-ref,omitempty"`
+	Type       string        `json:"type"`
+	Name       string        `json:"name"`
+	Version    string        `json:"version,omitempty"`
+	PURL       string        `json:"purl,omitempty"`
+	Licenses   []cdxLicense  `json:"licenses,omitempty"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxLicense struct {
+	License cdxLicenseName `json:"license"`
+}
+
+type cdxLicenseName struct {
+	Name string `json:"name"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// ToCycloneDX converts an inventory into a CycloneDX 1.5 JSON document
+func ToCycloneDX(inv *Inventory) (interface{}, error) {
+	id, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+	doc := cdxDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + id,
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Tools:     []cdxTool{{Name: "dockdiver"}},
+			Component: cdxComponent{Type: "container", Name: inv.Image},
+		},
+		Components: []cdxComponent{},
+	}
+
+	for i, pkg := range inv.Packages {
+		component := cdxComponent{
+			BOMRef:  fmt.Sprintf("%s#%d", pkg.PURL, i),
+			Type:    "library",
+			Name:    pkg.Name,
+			Version: pkg.Version,
+			PURL:    pkg.PURL,
+			Properties: []cdxProperty{
+				{Name: "dockdiver:location", Value: pkg.Location},
+			},
+		}
+		if pkg.License != "" {
+			component.Licenses = []cdxLicense{{License: cdxLicenseName{Name: pkg.License}}}
+		}
+		doc.Components = append(doc.Components, component)
+	}
+	return doc, nil
+}
@@ -0,0 +1,44 @@
+package sbom
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestToSPDXLicenses(t *testing.T) {
+	inv := &Inventory{
+		Image: "team/app:1.0",
+		Packages: []Package{
+			{Name: "a", Version: "1", License: "MIT"},
+			{Name: "b", Version: "1", License: "Apache License 2.0"},
+			{Name: "c", Version: "1", License: "BSD"},
+			{Name: "d", Version: "1", License: "BSD"},
+			{Name: "e", Version: "1", License: "GPL v2"},
+			{Name: "f", Version: "1", License: "GPL/v2"},
+			{Name: "g", Version: "1"},
+		},
+	}
+	out, err := ToSPDX(inv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := out.(spdxDocument)
+
+	var declared []string
+	for _, pkg := range doc.Packages[1:] {
+		declared = append(declared, pkg.LicenseDeclared)
+	}
+	wantDeclared := []string{"MIT", "Apache-2.0", "LicenseRef-BSD", "LicenseRef-BSD", "LicenseRef-GPL-v2", "LicenseRef-GPL-v2-2", "NOASSERTION"}
+	if !reflect.DeepEqual(declared, wantDeclared) {
+		t.Errorf("licenseDeclared = %v, want %v", declared, wantDeclared)
+	}
+
+	wantExtracted := []spdxLicenseInfo{
+		{LicenseID: "LicenseRef-BSD", ExtractedText: "BSD", Name: "BSD"},
+		{LicenseID: "LicenseRef-GPL-v2", ExtractedText: "GPL v2", Name: "GPL v2"},
+		{LicenseID: "LicenseRef-GPL-v2-2", ExtractedText: "GPL/v2", Name: "GPL/v2"},
+	}
+	if !reflect.DeepEqual(doc.ExtractedLicenses, wantExtracted) {
+		t.Errorf("hasExtractedLicensingInfos = %+v, want %+v", doc.ExtractedLicenses, wantExtracted)
+	}
+}
@@ -0,0 +1,184 @@
+package sbom
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"dockdiver/layer"
+	"dockdiver/utils"
+)
+
+// Package types, which double as purl types
+const (
+	TypeDeb    = "deb"
+	TypeApk    = "apk"
+	TypeRpm    = "rpm"
+	TypePyPI   = "pypi"
+	TypeNpm    = "npm"
+	TypeMaven  = "maven"
+	TypeGolang = "golang"
+)
+
+const (
+	maxArchiveSize = 64 * 1024 * 1024  // Largest JAR/WAR/EAR that is inspected
+	maxBinarySize  = 128 * 1024 * 1024 // Largest executable checked for Go build info
+)
+
+// Package is a single piece of software found in an image
+type Package struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Type     string `json:"type"`
+	Location string `json:"location"`
+	License  string `json:"license,omitempty"`
+	Arch     string `json:"arch,omitempty"`
+	Source   string `json:"source,omitempty"` // Source package of distro packages
+	PURL     string `json:"purl"`
+}
+
+// Inventory lists the software installed in a dumped image
+type Inventory struct {
+	Image     string    `json:"image"`
+	OS        string    `json:"os,omitempty"`
+	OSVersion string    `json:"osVersion,omitempty"`
+	Packages  []Package `json:"packages"`
+	Warnings  []string  `json:"warnings,omitempty"`
+}
+
+// osRelease holds the fields of /etc/os-release that identify the distro
+type osRelease struct {
+	ID        string
+	VersionID string
+}
+
+// rpmDatabase marks an rpm database that dockdiver cannot parse
+type rpmDatabase struct{}
+
+// Collect reconstructs the filesystem of an image from its ordered layers
+// and builds its software inventory
+func Collect(image string, layers []layer.Source) (*Inventory, error) {
+	files, err := layer.Flatten(layers, extract)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	inv := &Inventory{Image: image, Packages: []Package{}}
+	for _, name := range names {
+		switch v := files[name].(type) {
+		case osRelease:
+			// /etc/os-release takes precedence over /usr/lib/os-release
+			if inv.OS == "" || name == "/etc/os-release" {
+				inv.OS, inv.OSVersion = v.ID, v.VersionID
+			}
+		case rpmDatabase:
+			inv.Warnings = append(inv.Warnings, fmt.Sprintf("rpm database %s found but not parsed", name))
+		case []Package:
+			inv.Packages = append(inv.Packages, v...)
+		}
+	}
+
+	for i := range inv.Packages {
+		inv.Packages[i].PURL = purl(inv.Packages[i], inv.OS, inv.OSVersion)
+	}
+	return inv, nil
+}
+
+// Save writes the inventory, SPDX and CycloneDX documents into dir
+func (inv *Inventory) Save(dir string) error {
+	outputs := []struct {
+		name   string
+		encode func() (interface{}, error)
+	}{
+		{"inventory.json", func() (interface{}, error) { return inv, nil }},
+		{"sbom.spdx.json", func() (interface{}, error) { return ToSPDX(inv) }},
+		{"sbom.cdx.json", func() (interface{}, error) { return ToCycloneDX(inv) }},
+	}
+	for _, out := range outputs {
+		doc, err := out.encode()
+		if err != nil {
+			return fmt.Errorf("failed to build %s: %v", out.name, err)
+		}
+		data, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %v", out.name, err)
+		}
+		if err := utils.StoreResponse(filepath.Join(dir, out.name), data); err != nil {
+			return fmt.Errorf("failed to store %s: %v", out.name, err)
+		}
+	}
+	return nil
+}
+
+// extract picks out the files that describe installed software
+func extract(name string, hdr *tar.Header, r io.Reader) (interface{}, error) {
+	if hdr.Typeflag != tar.TypeReg {
+		return nil, nil
+	}
+	base := path.Base(name)
+	ext := strings.ToLower(path.Ext(name))
+
+	switch {
+	case name == "/etc/os-release" || name == "/usr/lib/os-release":
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return parseOSRelease(data), nil
+	case name == "/var/lib/dpkg/status" || strings.HasPrefix(name, "/var/lib/dpkg/status.d/"):
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return parseDpkgStatus(data, name), nil
+	case name == "/lib/apk/db/installed":
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return parseApkInstalled(data, name), nil
+	case strings.HasPrefix(name, "/var/lib/rpm/") && (base == "Packages" || base == "Packages.db" || base == "rpmdb.sqlite"):
+		return rpmDatabase{}, nil
+	case strings.HasSuffix(name, ".dist-info/METADATA") || strings.HasSuffix(name, ".egg-info/PKG-INFO"):
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return parsePythonMetadata(data, name), nil
+	case base == "package.json" && strings.Contains(name, "/node_modules/"):
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return parseNpmPackage(data, name), nil
+	case (ext == ".jar" || ext == ".war" || ext == ".ear") && hdr.Size <= maxArchiveSize:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return parseJavaArchive(data, name), nil
+	case hdr.FileInfo().Mode()&0111 != 0 && hdr.Size <= maxBinarySize:
+		magic := make([]byte, 4)
+		if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, []byte("\x7fELF")) {
+			return nil, nil
+		}
+		rest, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return parseGoBinary(append(magic, rest...), name), nil
+	}
+	return nil, nil
+}
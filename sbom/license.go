@@ -0,0 +1,125 @@
+package sbom
+
+import "strings"
+
+// spdxLicenseIDs lists the SPDX license identifiers package metadata commonly
+// uses, including deprecated ones that remain valid, keyed by lower case
+var spdxLicenseIDs = indexLicenses(
+	"0BSD", "AFL-2.1", "AGPL-3.0", "AGPL-3.0-only", "AGPL-3.0-or-later",
+	"Apache-1.1", "Apache-2.0", "Artistic-1.0", "Artistic-1.0-Perl", "Artistic-2.0",
+	"Beerware", "BlueOak-1.0.0", "BSD-1-Clause", "BSD-2-Clause", "BSD-3-Clause",
+	"BSD-4-Clause", "BSL-1.0", "bzip2-1.0.6", "CC-BY-3.0", "CC-BY-4.0",
+	"CC-BY-SA-3.0", "CC-BY-SA-4.0", "CC0-1.0", "CDDL-1.0", "CDDL-1.1", "curl",
+	"EPL-1.0", "EPL-2.0", "EUPL-1.2", "FTL", "GFDL-1.3-or-later", "GPL-1.0-or-later",
+	"GPL-2.0", "GPL-2.0+", "GPL-2.0-only", "GPL-2.0-or-later", "GPL-3.0", "GPL-3.0+",
+	"GPL-3.0-only", "GPL-3.0-or-later", "HPND", "ISC", "LGPL-2.0", "LGPL-2.0+",
+	"LGPL-2.0-only", "LGPL-2.0-or-later", "LGPL-2.1", "LGPL-2.1+", "LGPL-2.1-only",
+	"LGPL-2.1-or-later", "LGPL-3.0", "LGPL-3.0+", "LGPL-3.0-only", "LGPL-3.0-or-later",
+	"Libpng", "libpng-2.0", "MIT", "MIT-0", "MPL-1.1", "MPL-2.0", "OpenSSL",
+	"PostgreSQL", "PSF-2.0", "Python-2.0", "Ruby", "Unicode-DFS-2016", "Unlicense",
+	"Vim", "W3C", "WTFPL", "X11", "Zlib", "ZPL-2.1",
+)
+
+// spdxExceptionIDs lists the license exceptions allowed after WITH
+var spdxExceptionIDs = indexLicenses(
+	"Autoconf-exception-3.0", "Bison-exception-2.2", "Classpath-exception-2.0",
+	"GCC-exception-3.1", "LLVM-exception", "OpenSSL-exception",
+)
+
+// licenseAliases maps free-form license names found in PyPI, npm and Maven
+// metadata to SPDX identifiers, keyed by lower case
+var licenseAliases = map[string]string{
+	"apache 2":                                 "Apache-2.0",
+	"apache 2.0":                               "Apache-2.0",
+	"apache license 2.0":                       "Apache-2.0",
+	"apache license, version 2.0":              "Apache-2.0",
+	"apache software license":                  "Apache-2.0",
+	"apache software license 2.0":              "Apache-2.0",
+	"apache-2":                                 "Apache-2.0",
+	"asl 2.0":                                  "Apache-2.0",
+	"the apache license, version 2.0":          "Apache-2.0",
+	"the apache software license, version 2.0": "Apache-2.0",
+	"mit license":                              "MIT",
+	"the mit license":                          "MIT",
+	"expat":                                    "MIT",
+	"isc license":                              "ISC",
+	"new bsd license":                          "BSD-3-Clause",
+	"bsd 3-clause":                             "BSD-3-Clause",
+	"3-clause bsd license":                     "BSD-3-Clause",
+	"simplified bsd":                           "BSD-2-Clause",
+	"bsd 2-clause":                             "BSD-2-Clause",
+	"2-clause bsd license":                     "BSD-2-Clause",
+	"mpl 2.0":                                  "MPL-2.0",
+	"mozilla public license 2.0 (mpl 2.0)":     "MPL-2.0",
+	"gplv2":                                    "GPL-2.0-only",
+	"gplv3":                                    "GPL-3.0-only",
+	"lgplv3":                                   "LGPL-3.0-only",
+	"python software foundation license":       "PSF-2.0",
+	"zlib license":                             "Zlib",
+}
+
+func indexLicenses(ids ...string) map[string]string {
+	index := make(map[string]string, len(ids))
+	for _, id := range ids {
+		index[strings.ToLower(id)] = id
+	}
+	return index
+}
+
+// spdxExpression returns license as a valid SPDX license expression, with
+// identifiers in their canonical case, or false when it is not one
+func spdxExpression(license string) (string, bool) {
+	if id, ok := licenseAliases[strings.ToLower(strings.TrimSpace(license))]; ok {
+		return id, true
+	}
+
+	fields := strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(license))
+	if len(fields) == 0 {
+		return "", false
+	}
+	depth := 0
+	operand := false // Whether the previous token ends an operand
+	for i, field := range fields {
+		switch upper := strings.ToUpper(field); {
+		case upper == "AND" || upper == "OR":
+			if !operand {
+				return "", false
+			}
+			fields[i], operand = upper, false
+		case upper == "WITH":
+			if !operand || i+1 >= len(fields) {
+				return "", false
+			}
+			exception, ok := spdxExceptionIDs[strings.ToLower(fields[i+1])]
+			if !ok {
+				return "", false
+			}
+			fields[i], fields[i+1] = upper, exception
+		case field == "(":
+			if operand {
+				return "", false
+			}
+			depth++
+		case field == ")":
+			if !operand || depth == 0 {
+				return "", false
+			}
+			depth--
+		default:
+			if i > 0 && fields[i-1] == "WITH" {
+				continue // Exception, checked with WITH
+			}
+			id, ok := spdxLicenseIDs[strings.ToLower(field)]
+			if !ok || operand {
+				return "", false
+			}
+			fields[i], operand = id, true
+		}
+	}
+	if !operand || depth != 0 {
+		return "", false
+	}
+	// Parentheses were padded with spaces above, so take them out again
+	expr := strings.Join(fields, " ")
+	return strings.NewReplacer("( ", "(", " )", ")").Replace(expr), true
+}
@@ -0,0 +1,38 @@
+package sbom
+
+import "testing"
+
+func TestSPDXExpression(t *testing.T) {
+	tests := []struct {
+		license string
+		want    string
+		wantOK  bool
+	}{
+		{license: "MIT", want: "MIT", wantOK: true},
+		{license: "mit", want: "MIT", wantOK: true},
+		{license: "Apache Software License", want: "Apache-2.0", wantOK: true},
+		{license: "The Apache License, Version 2.0", want: "Apache-2.0", wantOK: true},
+		{license: "GPL-2.0-or-later", want: "GPL-2.0-or-later", wantOK: true},
+		{license: "GPL-2.0+", want: "GPL-2.0+", wantOK: true},
+		{license: "MIT AND BSD-3-Clause", want: "MIT AND BSD-3-Clause", wantOK: true},
+		{license: "(mit or apache-2.0)", want: "(MIT OR Apache-2.0)", wantOK: true},
+		{license: "GPL-2.0-only WITH classpath-exception-2.0", want: "GPL-2.0-only WITH Classpath-exception-2.0", wantOK: true},
+		{license: "(MIT OR Apache-2.0) AND Zlib", want: "(MIT OR Apache-2.0) AND Zlib", wantOK: true},
+		{license: "BSD"},
+		{license: "GPL"},
+		{license: "Public Domain"},
+		{license: "MIT AND"},
+		{license: "AND MIT"},
+		{license: "MIT Apache-2.0"},
+		{license: "(MIT"},
+		{license: "MIT)"},
+		{license: "GPL-2.0-only WITH Nonsense-exception"},
+		{license: ""},
+	}
+	for _, tt := range tests {
+		got, ok := spdxExpression(tt.license)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("spdxExpression(%q) = %q, %v, want %q, %v", tt.license, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
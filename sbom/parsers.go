@@ -0,0 +1,281 @@
+package sbom
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"debug/buildinfo"
+	"encoding/json"
+	"io"
+	"path"
+	"strings"
+)
+
+// parseOSRelease extracts the distro ID and version from an os-release file
+func parseOSRelease(data []byte) osRelease {
+	var rel osRelease
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "ID":
+			rel.ID = value
+		case "VERSION_ID":
+			rel.VersionID = value
+		}
+	}
+	return rel
+}
+
+// parseStanzas splits RFC 822 style control data into field maps. Continuation
+// lines are ignored since none of the fields dockdiver reads span lines.
+func parseStanzas(data []byte, sep string) []map[string]string {
+	var stanzas []map[string]string
+	current := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			if len(current) > 0 {
+				stanzas = append(stanzas, current)
+				current = make(map[string]string)
+			}
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, sep); ok {
+			if _, exists := current[key]; !exists {
+				current[key] = strings.TrimSpace(value)
+			}
+		}
+	}
+	if len(current) > 0 {
+		stanzas = append(stanzas, current)
+	}
+	return stanzas
+}
+
+// parseDpkgStatus reads the dpkg status database, or a distroless status.d entry
+func parseDpkgStatus(data []byte, location string) []Package {
+	var pkgs []Package
+	for _, fields := range parseStanzas(data, ":") {
+		if fields["Package"] == "" || fields["Version"] == "" {
+			continue
+		}
+		// status.d entries have no Status field and are always installed
+		if status, ok := fields["Status"]; ok && !strings.HasSuffix(status, " installed") {
+			continue
+		}
+		// Source may carry its own version, e.g. "openssl (3.0.11-1)"
+		source, _, _ := strings.Cut(fields["Source"], " ")
+		pkgs = append(pkgs, Package{
+			Name:     fields["Package"],
+			Version:  fields["Version"],
+			Type:     TypeDeb,
+			Location: location,
+			Arch:     fields["Architecture"],
+			Source:   source,
+		})
+	}
+	return pkgs
+}
+
+// parseApkInstalled reads the Alpine package database
+func parseApkInstalled(data []byte, location string) []Package {
+	var pkgs []Package
+	for _, fields := range parseStanzas(data, ":") {
+		if fields["P"] == "" || fields["V"] == "" {
+			continue
+		}
+		pkgs = append(pkgs, Package{
+			Name:     fields["P"],
+			Version:  fields["V"],
+			Type:     TypeApk,
+			Location: location,
+			License:  fields["L"],
+			Arch:     fields["A"],
+			Source:   fields["o"],
+		})
+	}
+	return pkgs
+}
+
+// parsePythonMetadata reads a dist-info METADATA or egg-info PKG-INFO file
+func parsePythonMetadata(data []byte, location string) []Package {
+	stanzas := parseStanzas(data, ":")
+	if len(stanzas) == 0 || stanzas[0]["Name"] == "" || stanzas[0]["Version"] == "" {
+		return nil
+	}
+	fields := stanzas[0]
+	return []Package{{
+		Name:     fields["Name"],
+		Version:  fields["Version"],
+		Type:     TypePyPI,
+		Location: location,
+		License:  fields["License"],
+	}}
+}
+
+// parseNpmPackage reads a package.json from a node_modules tree
+func parseNpmPackage(data []byte, location string) []Package {
+	var pkg struct {
+		Name    string          `json:"name"`
+		Version string          `json:"version"`
+		License json.RawMessage `json:"license"`
+	}
+	if err := json.Unmarshal(data, &pkg); err != nil || pkg.Name == "" || pkg.Version == "" {
+		return nil
+	}
+
+	// license is either an SPDX expression or a legacy {"type": ...} object
+	var license string
+	if err := json.Unmarshal(pkg.License, &license); err != nil {
+		var legacy struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(pkg.License, &legacy) == nil {
+			license = legacy.Type
+		}
+	}
+	return []Package{{
+		Name:     pkg.Name,
+		Version:  pkg.Version,
+		Type:     TypeNpm,
+		Location: location,
+		License:  license,
+	}}
+}
+
+// parseJavaArchive reads Maven pom.properties and the manifest of a JAR, WAR
+// or EAR, descending one level into nested archives such as Spring Boot jars
+func parseJavaArchive(data []byte, location string) []Package {
+	return parseJavaArchiveDepth(data, location, 1)
+}
+
+func parseJavaArchiveDepth(data []byte, location string, depth int) []Package {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil
+	}
+
+	var pkgs []Package
+	var manifest map[string]string
+	for _, f := range zr.File {
+		name := f.Name
+		switch {
+		case strings.HasPrefix(name, "META-INF/maven/") && strings.HasSuffix(name, "/pom.properties"):
+			props := readZipProperties(f)
+			if props["artifactId"] != "" && props["version"] != "" {
+				pkgs = append(pkgs, Package{
+					Name:     props["groupId"] + ":" + props["artifactId"],
+					Version:  props["version"],
+					Type:     TypeMaven,
+					Location: location,
+				})
+			}
+		case name == "META-INF/MANIFEST.MF":
+			if stanzas := parseStanzas(readZipFile(f), ":"); len(stanzas) > 0 {
+				manifest = stanzas[0]
+			}
+		case depth > 0 && strings.HasSuffix(strings.ToLower(name), ".jar"):
+			nested := readZipFile(f)
+			pkgs = append(pkgs, parseJavaArchiveDepth(nested, location+"!/"+name, depth-1)...)
+		}
+	}
+
+	// Fall back to the manifest when the archive carries no Maven metadata
+	if len(pkgs) == 0 && manifest != nil {
+		name := manifest["Implementation-Title"]
+		version := manifest["Implementation-Version"]
+		if name == "" {
+			name = manifest["Bundle-SymbolicName"]
+			version = manifest["Bundle-Version"]
+		}
+		if name == "" {
+			name = strings.TrimSuffix(path.Base(location), path.Ext(location))
+		}
+		if version != "" {
+			pkgs = append(pkgs, Package{
+				Name:     name,
+				Version:  version,
+				Type:     TypeMaven,
+				Location: location,
+				License:  manifest["Bundle-License"],
+			})
+		}
+	}
+	return pkgs
+}
+
+func readZipFile(f *zip.File) []byte {
+	if f.UncompressedSize64 > maxArchiveSize {
+		return nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func readZipProperties(f *zip.File) map[string]string {
+	props := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(readZipFile(f)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			props[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return props
+}
+
+// parseGoBinary reads the module build information embedded in a Go executable
+func parseGoBinary(data []byte, location string) []Package {
+	info, err := buildinfo.Read(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+
+	pkgs := []Package{{
+		Name:     "stdlib",
+		Version:  strings.TrimPrefix(info.GoVersion, "go"),
+		Type:     TypeGolang,
+		Location: location,
+	}}
+	if info.Main.Path != "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		pkgs = append(pkgs, Package{
+			Name:     info.Main.Path,
+			Version:  info.Main.Version,
+			Type:     TypeGolang,
+			Location: location,
+		})
+	}
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		if dep.Version == "" {
+			continue
+		}
+		pkgs = append(pkgs, Package{
+			Name:     dep.Path,
+			Version:  dep.Version,
+			Type:     TypeGolang,
+			Location: location,
+		})
+	}
+	return pkgs
+}
@@ -0,0 +1,132 @@
+package sbom
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseOSRelease(t *testing.T) {
+	tests := []struct {
+		data string
+		want osRelease
+	}{
+		{
+			data: "NAME=\"Debian GNU/Linux\"\nID=debian\nVERSION_ID=\"12\"\n",
+			want: osRelease{ID: "debian", VersionID: "12"},
+		},
+		{
+			data: "ID='alpine'\r\nVERSION_ID=3.19.1\r\n",
+			want: osRelease{ID: "alpine", VersionID: "3.19.1"},
+		},
+		{
+			data: "# comment\nID_LIKE=rhel\n",
+			want: osRelease{},
+		},
+	}
+	for _, tt := range tests {
+		if got := parseOSRelease([]byte(tt.data)); got != tt.want {
+			t.Errorf("parseOSRelease(%q) = %+v, want %+v", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestParseDpkgStatus(t *testing.T) {
+	status := `Package: libssl3
+Status: install ok installed
+Architecture: amd64
+Source: openssl (3.0.11-1)
+Version: 3.0.11-1~deb12u2
+Description: Secure Sockets Layer toolkit
+ continuation line
+Version: ignored
+
+Package: removed
+Status: deinstall ok config-files
+Version: 1.0
+
+Package: nover
+Status: install ok installed
+
+Package: base-files
+Version: 12.4+deb12u5
+Architecture: amd64
+`
+	want := []Package{
+		{Name: "libssl3", Version: "3.0.11-1~deb12u2", Type: TypeDeb, Location: "/var/lib/dpkg/status", Arch: "amd64", Source: "openssl"},
+		{Name: "base-files", Version: "12.4+deb12u5", Type: TypeDeb, Location: "/var/lib/dpkg/status", Arch: "amd64"},
+	}
+	if got := parseDpkgStatus([]byte(status), "/var/lib/dpkg/status"); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDpkgStatus() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseApkInstalled(t *testing.T) {
+	installed := "C:Q1abc=\nP:musl\nV:1.2.4-r2\nA:x86_64\nL:MIT\no:musl\n\nP:broken\n\nP:busybox\nV:1.36.1-r15\nA:x86_64\nL:GPL-2.0-only\no:busybox\n"
+	want := []Package{
+		{Name: "musl", Version: "1.2.4-r2", Type: TypeApk, Location: "/lib/apk/db/installed", License: "MIT", Arch: "x86_64", Source: "musl"},
+		{Name: "busybox", Version: "1.36.1-r15", Type: TypeApk, Location: "/lib/apk/db/installed", License: "GPL-2.0-only", Arch: "x86_64", Source: "busybox"},
+	}
+	if got := parseApkInstalled([]byte(installed), "/lib/apk/db/installed"); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseApkInstalled() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParsePythonMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []Package
+	}{
+		{
+			name: "dist-info",
+			data: "Metadata-Version: 2.1\nName: requests\nVersion: 2.31.0\nLicense: Apache 2.0\n\nlong description\nName: other\n",
+			want: []Package{{Name: "requests", Version: "2.31.0", Type: TypePyPI, Location: "loc", License: "Apache 2.0"}},
+		},
+		{
+			name: "missing version",
+			data: "Metadata-Version: 2.1\nName: requests\n",
+		},
+	}
+	for _, tt := range tests {
+		if got := parsePythonMetadata([]byte(tt.data), "loc"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parsePythonMetadata() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseNpmPackage(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []Package
+	}{
+		{
+			name: "SPDX license",
+			data: `{"name": "lodash", "version": "4.17.21", "license": "MIT"}`,
+			want: []Package{{Name: "lodash", Version: "4.17.21", Type: TypeNpm, Location: "loc", License: "MIT"}},
+		},
+		{
+			name: "legacy license object",
+			data: `{"name": "old", "version": "0.1.0", "license": {"type": "BSD", "url": "x"}}`,
+			want: []Package{{Name: "old", Version: "0.1.0", Type: TypeNpm, Location: "loc", License: "BSD"}},
+		},
+		{
+			name: "no license",
+			data: `{"name": "bare", "version": "1.0.0"}`,
+			want: []Package{{Name: "bare", Version: "1.0.0", Type: TypeNpm, Location: "loc"}},
+		},
+		{
+			name: "missing version",
+			data: `{"name": "workspace"}`,
+		},
+		{
+			name: "invalid JSON",
+			data: `{`,
+		},
+	}
+	for _, tt := range tests {
+		if got := parseNpmPackage([]byte(tt.data), "loc"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseNpmPackage() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,10 +1,12 @@
 package utils
 
 import (
+	"bufio"
 	"crypto/sha256"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 )
 
@@ -30,4 +32,33 @@ func StoreResponse(filename string, data []byte) error {
 func URLToFilename(url string) string {
 	hash := sha256.Sum256([]byte(url))
 	return fmt.Sprintf("%x.json", hash)
-}
\ No newline at end of file
+}
+
+// ReadWordlist returns the distinct entries of a wordlist file, one per
+// line, skipping blank lines and # comments
+func ReadWordlist(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open wordlist: %v", err)
+	}
+	defer f.Close()
+
+	var words []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "#") || seen[word] {
+			continue
+		}
+		seen[word] = true
+		words = append(words, word)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read wordlist %s: %v", path, err)
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("wordlist %s is empty", path)
+	}
+	return words, nil
+}
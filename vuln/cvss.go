@@ -0,0 +1,112 @@
+package vuln
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+var cvssWeights = map[string]map[string]float64{
+	"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+	"AC": {"L": 0.77, "H": 0.44},
+	"UI": {"N": 0.85, "R": 0.62},
+	"C":  {"H": 0.56, "L": 0.22, "N": 0},
+	"I":  {"H": 0.56, "L": 0.22, "N": 0},
+	"A":  {"H": 0.56, "L": 0.22, "N": 0},
+}
+
+// cvss3Score computes the base score of a CVSS v3.x vector string
+func cvss3Score(vector string) (float64, bool) {
+	metrics := make(map[string]string)
+	for _, part := range strings.Split(vector, "/") {
+		if key, value, ok := strings.Cut(part, ":"); ok {
+			metrics[key] = value
+		}
+	}
+
+	values := make(map[string]float64)
+	for metric, weights := range cvssWeights {
+		w, ok := weights[metrics[metric]]
+		if !ok {
+			return 0, false
+		}
+		values[metric] = w
+	}
+
+	changed := metrics["S"] == "C"
+	var pr float64
+	switch metrics["PR"] {
+	case "N":
+		pr = 0.85
+	case "L":
+		pr = 0.62
+		if changed {
+			pr = 0.68
+		}
+	case "H":
+		pr = 0.27
+		if changed {
+			pr = 0.5
+		}
+	default:
+		return 0, false
+	}
+
+	iss := 1 - (1-values["C"])*(1-values["I"])*(1-values["A"])
+	impact := 6.42 * iss
+	if changed {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	}
+	if impact <= 0 {
+		return 0, true
+	}
+	exploitability := 8.22 * values["AV"] * values["AC"] * pr * values["UI"]
+	if changed {
+		return roundUp(math.Min(1.08*(impact+exploitability), 10)), true
+	}
+	return roundUp(math.Min(impact+exploitability, 10)), true
+}
+
+// roundUp rounds to one decimal place as defined by the CVSS v3.1 specification
+func roundUp(x float64) float64 {
+	i := int(math.Round(x * 100000))
+	if i%10000 == 0 {
+		return float64(i) / 100000
+	}
+	return (math.Floor(float64(i)/10000) + 1) / 10
+}
+
+// ratingForScore maps a CVSS score to its qualitative severity rating
+func ratingForScore(score float64) string {
+	switch {
+	case score == 0:
+		return "NONE"
+	case score < 4:
+		return "LOW"
+	case score < 7:
+		return "MEDIUM"
+	case score < 9:
+		return "HIGH"
+	}
+	return "CRITICAL"
+}
+
+// severityOf picks the most specific severity available for an affected entry
+func severityOf(adv *Advisory, a affected) string {
+	for _, s := range []string{a.EcosystemSpecific.Severity, a.DatabaseSpecific.Severity, adv.DatabaseSpecific.Severity} {
+		if s != "" {
+			return strings.ToUpper(s)
+		}
+	}
+	for _, s := range adv.Severity {
+		if strings.HasPrefix(s.Type, "CVSS_V3") {
+			if score, ok := cvss3Score(s.Score); ok {
+				return ratingForScore(score)
+			}
+		}
+		if score, err := strconv.ParseFloat(s.Score, 64); err == nil {
+			return ratingForScore(score)
+		}
+	}
+	return "UNKNOWN"
+}
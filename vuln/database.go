@@ -0,0 +1,171 @@
+package vuln
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Advisory is the subset of an OSV record that dockdiver needs for matching
+type Advisory struct {
+	ID       string     `json:"id"`
+	Aliases  []string   `json:"aliases"`
+	Summary  string     `json:"summary"`
+	Severity []severity `json:"severity"`
+	Affected []affected `json:"affected"`
+
+	DatabaseSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"database_specific"`
+}
+
+type severity struct {
+	Type  string `json:"type"`
+	Score string `json:"score"`
+}
+
+type affected struct {
+	Package struct {
+		Ecosystem string `json:"ecosystem"`
+		Name      string `json:"name"`
+	} `json:"package"`
+	Ranges   []affectedRange `json:"ranges"`
+	Versions []string        `json:"versions"`
+
+	EcosystemSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"ecosystem_specific"`
+	DatabaseSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"database_specific"`
+}
+
+type affectedRange struct {
+	Type   string  `json:"type"`
+	Events []event `json:"events"`
+}
+
+type event struct {
+	Introduced   string `json:"introduced,omitempty"`
+	Fixed        string `json:"fixed,omitempty"`
+	LastAffected string `json:"last_affected,omitempty"`
+	Limit        string `json:"limit,omitempty"`
+}
+
+// Database is an in-memory OSV advisory set indexed by ecosystem and package
+type Database struct {
+	index map[string][]*Advisory
+	count int
+}
+
+// LoadDatabase reads OSV JSON records from a directory tree or a zip file.
+// Directories may contain loose .json records and the per-ecosystem all.zip
+// archives published by osv.dev.
+func LoadDatabase(path string) (*Database, error) {
+	db := &Database{index: make(map[string][]*Advisory)}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open OSV database %s: %v", path, err)
+	}
+	if !info.IsDir() {
+		if err := db.loadZip(path); err != nil {
+			return nil, err
+		}
+		return db, nil
+	}
+
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		switch strings.ToLower(filepath.Ext(p)) {
+		case ".json":
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return db.add(f, p)
+		case ".zip":
+			return db.loadZip(p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OSV database %s: %v", path, err)
+	}
+	return db, nil
+}
+
+// Len returns the number of advisories loaded
+func (db *Database) Len() int {
+	return db.count
+}
+
+func (db *Database) loadZip(path string) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("failed to open OSV archive %s: %v", path, err)
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if !strings.HasSuffix(strings.ToLower(f.Name), ".json") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("failed to read %s from %s: %v", f.Name, path, err)
+		}
+		err = db.add(rc, path+"!/"+f.Name)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *Database) add(r io.Reader, name string) error {
+	var adv Advisory
+	if err := json.NewDecoder(r).Decode(&adv); err != nil {
+		return fmt.Errorf("failed to parse OSV record %s: %v", name, err)
+	}
+	if adv.ID == "" {
+		return nil
+	}
+
+	// Index each package once even if it is listed under several ecosystem releases
+	seen := make(map[string]bool)
+	for _, a := range adv.Affected {
+		key := indexKey(baseEcosystem(a.Package.Ecosystem), a.Package.Name)
+		if !seen[key] {
+			db.index[key] = append(db.index[key], &adv)
+			seen[key] = true
+		}
+	}
+	db.count++
+	return nil
+}
+
+// baseEcosystem strips the release suffix, e.g. "Debian:12" becomes "Debian"
+func baseEcosystem(ecosystem string) string {
+	base, _, _ := strings.Cut(ecosystem, ":")
+	return base
+}
+
+// indexKey builds the lookup key for a package, normalizing PyPI names which
+// are case-insensitive and treat '-', '_' and '.' alike
+func indexKey(ecosystem, name string) string {
+	ecosystem = strings.ToLower(ecosystem)
+	if ecosystem == "pypi" {
+		name = strings.ToLower(strings.NewReplacer("_", "-", ".", "-").Replace(name))
+	}
+	return ecosystem + "/" + name
+}
@@ -0,0 +1,231 @@
+package vuln
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"dockdiver/sbom"
+	"dockdiver/utils"
+)
+
+// Finding is an advisory that affects an installed package
+type Finding struct {
+	ID        string   `json:"id"`
+	CVEs      []string `json:"cves,omitempty"`
+	Package   string   `json:"package"`
+	Version   string   `json:"installedVersion"`
+	Fixed     string   `json:"fixedVersion,omitempty"`
+	Ecosystem string   `json:"ecosystem"`
+	Severity  string   `json:"severity"`
+	Summary   string   `json:"summary,omitempty"`
+	Location  string   `json:"location"`
+}
+
+// severityOrder ranks severities from most to least urgent for sorting
+var severityOrder = map[string]int{"CRITICAL": 0, "HIGH": 1, "MEDIUM": 2, "MODERATE": 2, "LOW": 3}
+
+// ecosystemFor maps an inventory package to its OSV ecosystem
+func ecosystemFor(pkg sbom.Package, osID string) string {
+	switch pkg.Type {
+	case sbom.TypeDeb:
+		switch osID {
+		case "debian":
+			return "Debian"
+		case "ubuntu":
+			return "Ubuntu"
+		}
+	case sbom.TypeApk:
+		return "Alpine"
+	case sbom.TypePyPI:
+		return "PyPI"
+	case sbom.TypeNpm:
+		return "npm"
+	case sbom.TypeMaven:
+		return "Maven"
+	case sbom.TypeGolang:
+		return "Go"
+	}
+	return ""
+}
+
+// releaseMatches reports whether an advisory scoped to an ecosystem release,
+// such as "Debian:12" or "Alpine:v3.18", applies to the image's OS version
+func releaseMatches(ecosystem, osVersion string) bool {
+	_, release, ok := strings.Cut(ecosystem, ":")
+	if !ok || osVersion == "" {
+		return true
+	}
+	release, _, _ = strings.Cut(release, ":")
+	release = strings.TrimPrefix(release, "v")
+	return osVersion == release || strings.HasPrefix(osVersion, release+".")
+}
+
+// Match returns the advisories that affect packages of the inventory
+func (db *Database) Match(inv *sbom.Inventory) []Finding {
+	var findings []Finding
+	seen := make(map[string]bool)
+	for _, pkg := range inv.Packages {
+		ecosystem := ecosystemFor(pkg, inv.OS)
+		if ecosystem == "" {
+			continue
+		}
+		// Distro advisories are published against source packages
+		name := pkg.Name
+		if pkg.Source != "" {
+			name = pkg.Source
+		}
+		version := pkg.Version
+		if ecosystem == "Go" {
+			version = strings.TrimPrefix(version, "v")
+		}
+
+		for _, adv := range db.index[indexKey(ecosystem, name)] {
+			for _, a := range adv.Affected {
+				if baseEcosystem(a.Package.Ecosystem) != ecosystem || indexKey(ecosystem, a.Package.Name) != indexKey(ecosystem, name) {
+					continue
+				}
+				if !releaseMatches(a.Package.Ecosystem, inv.OSVersion) {
+					continue
+				}
+				hit, fixed := isAffected(a, ecosystem, version)
+				if !hit {
+					continue
+				}
+				key := adv.ID + "|" + pkg.Name + "|" + pkg.Location
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				findings = append(findings, Finding{
+					ID:        adv.ID,
+					CVEs:      cveIDs(adv),
+					Package:   pkg.Name,
+					Version:   pkg.Version,
+					Fixed:     fixed,
+					Ecosystem: a.Package.Ecosystem,
+					Severity:  severityOf(adv, a),
+					Summary:   adv.Summary,
+					Location:  pkg.Location,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		ri, oki := severityOrder[findings[i].Severity]
+		rj, okj := severityOrder[findings[j].Severity]
+		if !oki {
+			ri = len(severityOrder)
+		}
+		if !okj {
+			rj = len(severityOrder)
+		}
+		if ri != rj {
+			return ri < rj
+		}
+		return findings[i].Package < findings[j].Package
+	})
+	return findings
+}
+
+// isAffected evaluates the affected versions and ranges of an OSV entry and
+// returns the first fixed version above the installed one when known
+func isAffected(a affected, ecosystem, version string) (bool, string) {
+	listed := false
+	for _, v := range a.Versions {
+		if v == version {
+			listed = true
+			break
+		}
+	}
+
+	for _, r := range a.Ranges {
+		// Git ranges refer to commits, which an installed package doesn't carry
+		if r.Type == "GIT" {
+			continue
+		}
+		events := append([]event(nil), r.Events...)
+		sort.SliceStable(events, func(i, j int) bool {
+			return compareEventVersions(ecosystem, eventVersion(events[i]), eventVersion(events[j])) < 0
+		})
+
+		hit := false
+		fixed := ""
+		for _, e := range events {
+			switch {
+			case e.Introduced != "":
+				if e.Introduced == "0" || compareVersions(ecosystem, version, e.Introduced) >= 0 {
+					hit, fixed = true, ""
+				}
+			case e.Fixed != "":
+				if compareVersions(ecosystem, version, e.Fixed) >= 0 {
+					hit = false
+				} else if hit && fixed == "" {
+					fixed = e.Fixed
+				}
+			case e.LastAffected != "":
+				if compareVersions(ecosystem, version, e.LastAffected) > 0 {
+					hit = false
+				}
+			case e.Limit != "":
+				if compareVersions(ecosystem, version, e.Limit) >= 0 {
+					hit = false
+				}
+			}
+		}
+		if hit || listed {
+			return true, fixed
+		}
+	}
+	return listed, ""
+}
+
+func eventVersion(e event) string {
+	for _, v := range []string{e.Introduced, e.Fixed, e.LastAffected, e.Limit} {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// compareEventVersions orders event versions, with "0" before everything
+func compareEventVersions(ecosystem, a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "0":
+		return -1
+	case b == "0":
+		return 1
+	}
+	return compareVersions(ecosystem, a, b)
+}
+
+func cveIDs(adv *Advisory) []string {
+	var cves []string
+	for _, id := range append([]string{adv.ID}, adv.Aliases...) {
+		if strings.HasPrefix(id, "CVE-") {
+			cves = append(cves, id)
+		}
+	}
+	return cves
+}
+
+// SaveFindings writes the findings as vulnerabilities.json into dir
+func SaveFindings(dir string, findings []Finding) error {
+	if findings == nil {
+		findings = []Finding{}
+	}
+	data, err := json.MarshalIndent(findings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode vulnerabilities: %v", err)
+	}
+	if err := utils.StoreResponse(filepath.Join(dir, "vulnerabilities.json"), data); err != nil {
+		return fmt.Errorf("failed to store vulnerabilities: %v", err)
+	}
+	return nil
+}
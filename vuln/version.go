@@ -0,0 +1,257 @@
+package vuln
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// compareVersions orders two versions using the rules of the given ecosystem
+func compareVersions(ecosystem, a, b string) int {
+	switch ecosystem {
+	case "Debian", "Ubuntu":
+		return compareDebian(a, b)
+	default:
+		return compareGeneric(a, b)
+	}
+}
+
+// compareDebian implements dpkg's [epoch:]upstream[-revision] ordering
+func compareDebian(a, b string) int {
+	ea, ua, ra := splitDebian(a)
+	eb, ub, rb := splitDebian(b)
+	if ea != eb {
+		if ea < eb {
+			return -1
+		}
+		return 1
+	}
+	if c := verrevcmp(ua, ub); c != 0 {
+		return c
+	}
+	return verrevcmp(ra, rb)
+}
+
+func splitDebian(v string) (int, string, string) {
+	epoch := 0
+	if e, rest, ok := strings.Cut(v, ":"); ok {
+		if n, err := strconv.Atoi(e); err == nil {
+			epoch, v = n, rest
+		}
+	}
+	revision := ""
+	if i := strings.LastIndex(v, "-"); i >= 0 {
+		v, revision = v[:i], v[i+1:]
+	}
+	return epoch, v, revision
+}
+
+// debianOrder weights characters so that '~' sorts before everything, even
+// the end of the string, and letters sort before other symbols
+func debianOrder(s string, i int) int {
+	if i >= len(s) {
+		return 0
+	}
+	c := s[i]
+	switch {
+	case c >= '0' && c <= '9':
+		return 0
+	case unicode.IsLetter(rune(c)):
+		return int(c)
+	case c == '~':
+		return -1
+	default:
+		return int(c) + 256
+	}
+}
+
+func isDigit(s string, i int) bool {
+	return i < len(s) && s[i] >= '0' && s[i] <= '9'
+}
+
+func verrevcmp(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		firstDiff := 0
+		for (i < len(a) && !isDigit(a, i)) || (j < len(b) && !isDigit(b, j)) {
+			ac, bc := debianOrder(a, i), debianOrder(b, j)
+			if ac != bc {
+				return sign(ac - bc)
+			}
+			i++
+			j++
+		}
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		for isDigit(a, i) && isDigit(b, j) {
+			if firstDiff == 0 {
+				firstDiff = int(a[i]) - int(b[j])
+			}
+			i++
+			j++
+		}
+		if isDigit(a, i) {
+			return 1
+		}
+		if isDigit(b, j) {
+			return -1
+		}
+		if firstDiff != 0 {
+			return sign(firstDiff)
+		}
+	}
+	return 0
+}
+
+// versionToken is a numeric or alphabetic run of a version string
+type versionToken struct {
+	numeric bool
+	value   string
+}
+
+func tokenize(v string) []versionToken {
+	var tokens []versionToken
+	var current strings.Builder
+	currentNumeric := false
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, versionToken{numeric: currentNumeric, value: current.String()})
+			current.Reset()
+		}
+	}
+	for _, r := range v {
+		switch {
+		case unicode.IsDigit(r):
+			if !currentNumeric {
+				flush()
+			}
+			currentNumeric = true
+			current.WriteRune(r)
+		case unicode.IsLetter(r):
+			if currentNumeric {
+				flush()
+			}
+			currentNumeric = false
+			current.WriteRune(unicode.ToLower(r))
+		default:
+			flush()
+		}
+	}
+	flush()
+	return tokens
+}
+
+// preReleaseRank orders well-known qualifiers; unknown ones are compared lexically
+var preReleaseRank = map[string]int{
+	"dev": 0, "snapshot": 0,
+	"alpha": 1, "a": 1,
+	"beta": 2, "b": 2,
+	"pre": 3, "preview": 3,
+	"rc": 4, "c": 4, "cr": 4,
+	"final": 5, "ga": 5, "release": 5,
+	"post": 6, "p": 6, "pl": 6, "patch": 6, "r": 6, "sp": 6,
+}
+
+// isPostRelease reports whether a qualifier makes a version newer than the
+// same version without it, e.g. Alpine "-r1" or PyPI ".post1"
+func isPostRelease(value string) bool {
+	return preReleaseRank[value] > 5
+}
+
+// isReleaseMarker reports whether a qualifier only restates that a version is
+// a release, e.g. Maven "-Final" or "-GA"
+func isReleaseMarker(value string) bool {
+	rank, ok := preReleaseRank[value]
+	return ok && rank == 5
+}
+
+// compareGeneric orders semver, PyPI, Maven, npm, Go and Alpine versions well
+// enough for advisory matching
+func compareGeneric(a, b string) int {
+	a, _, _ = strings.Cut(strings.TrimPrefix(strings.ToLower(a), "v"), "+")
+	b, _, _ = strings.Cut(strings.TrimPrefix(strings.ToLower(b), "v"), "+")
+	ta, tb := tokenize(a), tokenize(b)
+
+	for i := 0; i < len(ta) || i < len(tb); i++ {
+		switch {
+		case i >= len(ta):
+			if s := tailSign(tb[i]); s != 0 {
+				return -s
+			}
+			continue
+		case i >= len(tb):
+			if s := tailSign(ta[i]); s != 0 {
+				return s
+			}
+			continue
+		}
+		x, y := ta[i], tb[i]
+		switch {
+		case x.numeric && y.numeric:
+			if c := compareNumeric(x.value, y.value); c != 0 {
+				return c
+			}
+		case x.numeric:
+			return numericVersusQualifier(y)
+		case y.numeric:
+			return -numericVersusQualifier(x)
+		default:
+			rx, okx := preReleaseRank[x.value]
+			ry, oky := preReleaseRank[y.value]
+			if okx && oky {
+				if rx != ry {
+					return sign(rx - ry)
+				}
+				continue
+			}
+			if c := strings.Compare(x.value, y.value); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
+}
+
+// tailSign returns how a version compares to its own prefix when the given
+// token is the first extra one: numbers and post-release qualifiers make it
+// newer, pre-release qualifiers make it older and release markers are ignored
+func tailSign(t versionToken) int {
+	switch {
+	case t.numeric || isPostRelease(t.value):
+		return 1
+	case isReleaseMarker(t.value):
+		return 0
+	}
+	return -1
+}
+
+// numericVersusQualifier compares a numeric token against qualifier q
+func numericVersusQualifier(q versionToken) int {
+	if isPostRelease(q.value) {
+		return -1
+	}
+	return 1
+}
+
+func compareNumeric(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return sign(len(a) - len(b))
+	}
+	return strings.Compare(a, b)
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,80 @@
+package vuln
+
+import "testing"
+
+func TestCompareDebian(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.10", "1.9", 1},
+		{"1:1.0", "2.0", 1},
+		{"0:1.0", "1.0", 0},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1.0a", "1.0+", -1},
+		{"1.0-1", "1.0-2", -1},
+		{"1.0-1", "1.0", 1},
+		{"2.36-9+deb12u3", "2.36-9+deb12u10", -1},
+		{"1.2.3-1ubuntu0.1", "1.2.3-1", 1},
+		{"1.001", "1.1", 0},
+	}
+	for _, tt := range tests {
+		if got := compareDebian(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareDebian(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareDebian(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareDebian(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestCompareGeneric(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2.3", "1.2.10", -1},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.0.1", "1.0", 1},
+		{"1.0+build5", "1.0", 0},
+		{"1.0.0-rc1", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0a1", "1.0", -1},
+		{"1.0.1", "1.0-rc1", 1},
+		{"1.0.post1", "1.0", 1},
+		{"3.18.4-r1", "3.18.4", 1},
+		{"3.18.4-r1", "3.18.4-r10", -1},
+		{"2.0.0-SNAPSHOT", "2.0.0-alpha", -1},
+		{"1.0-Final", "1.0", 0},
+		{"1.0.1", "1.0-GA", 1},
+	}
+	for _, tt := range tests {
+		if got := compareGeneric(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareGeneric(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareGeneric(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareGeneric(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestCompareVersionsEcosystem(t *testing.T) {
+	tests := []struct {
+		ecosystem string
+		want      int
+	}{
+		{"Debian", -1},
+		{"Ubuntu", -1},
+		{"PyPI", 1},
+		{"Alpine", 1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.ecosystem, "1.0~1", "1.0"); got != tt.want {
+			t.Errorf("compareVersions(%q, \"1.0~1\", \"1.0\") = %d, want %d", tt.ecosystem, got, tt.want)
+		}
+	}
+}